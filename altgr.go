@@ -0,0 +1,61 @@
+package main
+
+import "github.com/gdamore/tcell/v2"
+
+// altGrChars maps the characters AltGr adds to each built-in layout to the
+// key that produces them. Characters that are also a key's own legend are
+// left out, as a plain press of that key would be mistaken for AltGr.
+var altGrChars = map[string]map[rune]string{
+	"de": {
+		'²': "2", '³': "3", '{': "7", '[': "8", ']': "9", '}': "0",
+		'\\': "ß", '@': "Q", '€': "E", '~': "+", 'µ': "M",
+	},
+	"fr": {
+		'~': "É", '#': "\"", '{': "'", '[': "(", '|': "-", '`': "È",
+		'\\': "_", '@': "À", ']': ")", '}': "=", '€': "E", '¤': "$",
+	},
+}
+
+// altGrKey returns the key of layout that typed the event's character with
+// AltGr held. Terminals pass AltGr on as the bare character, or on Windows
+// as Ctrl+Alt with it; any other modifier means it was not AltGr.
+func altGrKey(layout string, ev *tcell.EventKey) (string, bool) {
+	if ev.Key() != tcell.KeyRune {
+		return "", false
+	}
+	label, ok := altGrChars[layout][ev.Rune()]
+	if !ok {
+		return "", false
+	}
+	if m := ev.Modifiers() & (tcell.ModCtrl | tcell.ModAlt); m != 0 && m != tcell.ModCtrl|tcell.ModAlt {
+		return "", false
+	}
+	return label, true
+}
+
+// optionChars maps the characters macOS types for Option plus a key of each
+// built-in layout back to that key. Keys that start a dead-key sequence are
+// missing, as they type nothing on their own. Layouts without an entry have
+// their own ß, µ and the like, so their Option characters are left alone.
+var optionChars = map[string]map[rune]string{
+	"us": {
+		'¡': "1", '™': "2", '£': "3", '¢': "4", '∞': "5", '§': "6", '¶': "7",
+		'•': "8", 'ª': "9", 'º': "0", '–': "-", '≠': "=",
+		'œ': "Q", '∑': "W", '®': "R", '†': "T", '¥': "Y", 'ø': "O", 'π': "P",
+		'“': "[", '‘': "]", '«': "\\",
+		'å': "A", 'ß': "S", '∂': "D", 'ƒ': "F", '©': "G", '˙': "H", '∆': "J",
+		'˚': "K", '¬': "L", '…': ";", 'æ': "'",
+		'Ω': "Z", '≈': "X", 'ç': "C", '√': "V", '∫': "B", 'µ': "M", '≤': ",",
+		'≥': ".", '÷': "/",
+	},
+}
+
+// optionKey returns the key of layout the event's character was typed with
+// while Option was held on a Mac
+func optionKey(layout string, ev *tcell.EventKey) (string, bool) {
+	if ev.Key() != tcell.KeyRune {
+		return "", false
+	}
+	label, ok := optionChars[layout][ev.Rune()]
+	return label, ok
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// caseLower and caseUpper are the bits -case-sensitive records per letter
+const (
+	caseLower = 1 << iota
+	caseUpper
+)
+
+// caseBit returns the bit for a typed rune, 0 if it has no case
+func caseBit(r rune) int {
+	switch {
+	case unicode.IsLower(r):
+		return caseLower
+	case unicode.IsUpper(r):
+		return caseUpper
+	}
+	return 0
+}
+
+// caseLetters returns the layout labels that are letters with two cases
+func caseLetters(keys []Key) []string {
+	var out []string
+	for _, i := range uniqueKeys(keys) {
+		label := keys[i].Label
+		r, n := utf8.DecodeRuneInString(label)
+		if n == len(label) && unicode.ToLower(r) != unicode.ToUpper(r) {
+			out = append(out, label)
+		}
+	}
+	return out
+}
+
+// caseReport lists the letters by the cases they were typed in, for the
+// exit report
+func caseReport(keys []Key, cases map[string]int) string {
+	var both, lower, upper, none []string
+	for _, label := range caseLetters(keys) {
+		switch cases[label] {
+		case caseLower | caseUpper:
+			both = append(both, label)
+		case caseLower:
+			lower = append(lower, label)
+		case caseUpper:
+			upper = append(upper, label)
+		default:
+			none = append(none, label)
+		}
+	}
+	return fmt.Sprintf("cases: both: %s\ncases: lower only: %s\ncases: upper only: %s\ncases: untested: %s",
+		labelList(both), labelList(lower), labelList(upper), labelList(none))
+}
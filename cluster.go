@@ -0,0 +1,59 @@
+package main
+
+import "github.com/gdamore/tcell/v2"
+
+// cluster is the bounding box of all keys sharing a Key.Cluster name
+type cluster struct {
+	Name           string
+	X0, Y0, X1, Y1 int // inclusive key area, border drawn one cell outside
+}
+
+// clusters groups the keys by cluster name in layout order
+func clusters(keys []Key) []cluster {
+	var out []cluster
+	index := map[string]int{}
+	for _, k := range keys {
+		if k.Cluster == "" {
+			continue
+		}
+		i, ok := index[k.Cluster]
+		if !ok {
+			index[k.Cluster] = len(out)
+			out = append(out, cluster{Name: k.Cluster, X0: k.X, Y0: k.Y, X1: k.X + k.W - 1, Y1: k.Y + k.H - 1})
+			continue
+		}
+		c := &out[i]
+		c.X0 = min(c.X0, k.X)
+		c.Y0 = min(c.Y0, k.Y)
+		c.X1 = max(c.X1, k.X+k.W-1)
+		c.Y1 = max(c.Y1, k.Y+k.H-1)
+	}
+	return out
+}
+
+// drawCluster draws a light border around the cluster with its name on the
+// top edge
+func drawCluster(s tcell.Screen, c cluster) {
+	style := tcell.StyleDefault.Foreground(tcell.ColorGray)
+	left, top, right, bottom := c.X0-1, c.Y0-1, c.X1+1, c.Y1+1
+	for x := left + 1; x < right; x++ {
+		s.SetContent(x, top, tcell.RuneHLine, nil, style)
+		s.SetContent(x, bottom, tcell.RuneHLine, nil, style)
+	}
+	for y := top + 1; y < bottom; y++ {
+		s.SetContent(left, y, tcell.RuneVLine, nil, style)
+		s.SetContent(right, y, tcell.RuneVLine, nil, style)
+	}
+	s.SetContent(left, top, tcell.RuneULCorner, nil, style)
+	s.SetContent(right, top, tcell.RuneURCorner, nil, style)
+	s.SetContent(left, bottom, tcell.RuneLLCorner, nil, style)
+	s.SetContent(right, bottom, tcell.RuneLRCorner, nil, style)
+
+	label := " " + c.Name + " "
+	for i, r := range []rune(label) {
+		if left+2+i >= right {
+			break
+		}
+		s.SetContent(left+2+i, top, r, nil, style)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadCounts reads per-key counts from a -save-session file or a CSV file
+// of "label,count" rows. A first row whose count is not a number is taken
+// as a header, and a label listed twice has its counts added up.
+func loadCounts(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var ss session
+		if err := json.Unmarshal(data, &ss); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		counts := map[string]int{}
+		for label, n := range ss.Counts {
+			if n < 0 {
+				return nil, fmt.Errorf("%s: negative count %d for %q", path, n, label)
+			}
+			counts[label] = n
+		}
+		return counts, nil
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 2
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	counts := map[string]int{}
+	for i, row := range rows {
+		n, err := strconv.Atoi(strings.TrimSpace(row[1]))
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: bad count %q", path, i+1, row[1])
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%s:%d: negative count %d", path, i+1, n)
+		}
+		counts[row[0]] += n
+	}
+	return counts, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCounts(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want map[string]int
+		err  string
+	}{
+		{"rows", "A,3\nSpace,12\n", map[string]int{"A": 3, "Space": 12}, ""},
+		{"header", "label,count\nA,3\n", map[string]int{"A": 3}, ""},
+		{"padded count", "A, 3 \n", map[string]int{"A": 3}, ""},
+		{"repeated label", "A,3\nB,1\nA,2\n", map[string]int{"A": 5, "B": 1}, ""},
+		{"quoted comma", "\",\",4\n", map[string]int{",": 4}, ""},
+		{"empty", "", map[string]int{}, ""},
+		{"bad count", "A,3\nB,x\n", nil, ":2: bad count"},
+		{"negative", "A,-1\n", nil, ":1: negative count"},
+		{"wrong field count", "A,1,2\n", nil, "wrong number of fields"},
+		{"session", `{"saved": "2024-01-01T00:00:00Z", "counts": {"A": 3}, "pressed": ["A"]}`, map[string]int{"A": 3}, ""},
+		{"session without counts", `{"pressed": []}`, map[string]int{}, ""},
+		{"negative session count", `{"counts": {"A": -2}}`, nil, "negative count -2"},
+		{"broken session", `{"counts": `, nil, "unexpected end"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "counts")
+		if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadCounts(path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%s: counts = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCountsFromSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	v := newTestView()
+	v.counts["A"], v.counts["Space"] = 3, 12
+	v.pressed["A"], v.pressed["Space"] = true, true
+	if err := saveSession(path, v); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := loadCounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(counts, v.counts) {
+		t.Errorf("loadCounts = %v, want the saved %v", counts, v.counts)
+	}
+}
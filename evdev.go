@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// evdevCode is a Linux input event key code with its KEY_ name
+type evdevCode struct {
+	code int
+	name string
+}
+
+// evdevKeys gives the codes of the US layout labels and the keys every
+// layout shares
+var evdevKeys = map[string]evdevCode{
+	"Esc": {1, "KEY_ESC"}, "1": {2, "KEY_1"}, "2": {3, "KEY_2"}, "3": {4, "KEY_3"},
+	"4": {5, "KEY_4"}, "5": {6, "KEY_5"}, "6": {7, "KEY_6"}, "7": {8, "KEY_7"},
+	"8": {9, "KEY_8"}, "9": {10, "KEY_9"}, "0": {11, "KEY_0"}, "-": {12, "KEY_MINUS"},
+	"=": {13, "KEY_EQUAL"}, "Backspace": {14, "KEY_BACKSPACE"}, "Tab": {15, "KEY_TAB"},
+	"Q": {16, "KEY_Q"}, "W": {17, "KEY_W"}, "E": {18, "KEY_E"}, "R": {19, "KEY_R"},
+	"T": {20, "KEY_T"}, "Y": {21, "KEY_Y"}, "U": {22, "KEY_U"}, "I": {23, "KEY_I"},
+	"O": {24, "KEY_O"}, "P": {25, "KEY_P"}, "[": {26, "KEY_LEFTBRACE"},
+	"]": {27, "KEY_RIGHTBRACE"}, "Enter": {28, "KEY_ENTER"}, "Ctrl": {29, "KEY_LEFTCTRL"},
+	"A": {30, "KEY_A"}, "S": {31, "KEY_S"}, "D": {32, "KEY_D"}, "F": {33, "KEY_F"},
+	"G": {34, "KEY_G"}, "H": {35, "KEY_H"}, "J": {36, "KEY_J"}, "K": {37, "KEY_K"},
+	"L": {38, "KEY_L"}, ";": {39, "KEY_SEMICOLON"}, "'": {40, "KEY_APOSTROPHE"},
+	"`": {41, "KEY_GRAVE"}, "Shift": {42, "KEY_LEFTSHIFT"}, "\\": {43, "KEY_BACKSLASH"},
+	"Z": {44, "KEY_Z"}, "X": {45, "KEY_X"}, "C": {46, "KEY_C"}, "V": {47, "KEY_V"},
+	"B": {48, "KEY_B"}, "N": {49, "KEY_N"}, "M": {50, "KEY_M"}, ",": {51, "KEY_COMMA"},
+	".": {52, "KEY_DOT"}, "/": {53, "KEY_SLASH"}, "Alt": {56, "KEY_LEFTALT"},
+	"Space": {57, "KEY_SPACE"}, "CapsLock": {58, "KEY_CAPSLOCK"},
+	"F1": {59, "KEY_F1"}, "F2": {60, "KEY_F2"}, "F3": {61, "KEY_F3"}, "F4": {62, "KEY_F4"},
+	"F5": {63, "KEY_F5"}, "F6": {64, "KEY_F6"}, "F7": {65, "KEY_F7"}, "F8": {66, "KEY_F8"},
+	"F9": {67, "KEY_F9"}, "F10": {68, "KEY_F10"}, "F11": {87, "KEY_F11"}, "F12": {88, "KEY_F12"},
+	"AltGr": {100, "KEY_RIGHTALT"}, "Home": {102, "KEY_HOME"}, "Up": {103, "KEY_UP"},
+	"PgUp": {104, "KEY_PAGEUP"}, "Left": {105, "KEY_LEFT"}, "Right": {106, "KEY_RIGHT"},
+	"End": {107, "KEY_END"}, "Down": {108, "KEY_DOWN"}, "PgDn": {109, "KEY_PAGEDOWN"},
+	"Insert": {110, "KEY_INSERT"}, "Delete": {111, "KEY_DELETE"}, "Win": {125, "KEY_LEFTMETA"},
+	"Menu": {127, "KEY_COMPOSE"}, "Fn": {464, "KEY_FN"},
+}
+
+// evdevUnknown stands in for labels with no known code
+var evdevUnknown = evdevCode{240, "KEY_UNKNOWN"}
+
+// evdevLog writes key events as evdev-style lines of timestamp, type, code
+// and value. Terminals report neither releases nor modifier keys on their
+// own, so every event is written as its modifiers and key going down, then
+// up, each step closed by a SYN_REPORT.
+type evdevLog struct {
+	f     *os.File
+	w     *bufio.Writer
+	codes map[string]evdevCode
+}
+
+// newEvdevLog creates path. Keys of a built-in layout other than us get
+// the code of the key at the same position on us.
+func newEvdevLog(path, layout string) (*evdevLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	codes := make(map[string]evdevCode, len(evdevKeys))
+	for label, c := range evdevKeys {
+		codes[label] = c
+	}
+	us := layoutRows["us"]
+	for r, row := range layoutRows[layout] {
+		for i, label := range row {
+			if c, ok := evdevKeys[us[r][i]]; ok {
+				codes[label] = c
+			}
+		}
+	}
+	return &evdevLog{f: f, w: bufio.NewWriter(f), codes: codes}, nil
+}
+
+func (e *evdevLog) keyEvent(ev *tcell.EventKey, label string) {
+	t := ev.When()
+	line := func(typ int, typName string, code int, codeName string, value int) {
+		fmt.Fprintf(e.w, "%d.%06d %s(%d) %s(%d) %d\n",
+			t.Unix(), t.Nanosecond()/1000, typName, typ, codeName, code, value)
+	}
+	syn := func() { line(0, "EV_SYN", 0, "SYN_REPORT", 0) }
+
+	var mods []evdevCode
+	for _, m := range []struct {
+		mask  tcell.ModMask
+		label string
+	}{{tcell.ModCtrl, "Ctrl"}, {tcell.ModAlt, "Alt"}, {tcell.ModShift, "Shift"}, {tcell.ModMeta, "Win"}} {
+		if ev.Modifiers()&m.mask != 0 {
+			mods = append(mods, evdevKeys[m.label])
+		}
+	}
+	key, ok := e.codes[label]
+	if !ok {
+		key = evdevUnknown
+	}
+	for _, m := range mods {
+		line(1, "EV_KEY", m.code, m.name, 1)
+	}
+	line(1, "EV_KEY", key.code, key.name, 1)
+	syn()
+	line(1, "EV_KEY", key.code, key.name, 0)
+	for _, m := range mods {
+		line(1, "EV_KEY", m.code, m.name, 0)
+	}
+	syn()
+	e.w.Flush()
+}
+
+func (e *evdevLog) close() error {
+	if err := e.w.Flush(); err != nil {
+		e.f.Close()
+		return err
+	}
+	return e.f.Close()
+}
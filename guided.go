@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// swapEvidence is how many different positions must produce another
+// layout's key before guided mode suggests switching to it
+const swapEvidence = 2
+
+// holdGap is the longest pause between events of a held key. Terminals
+// report no releases, so a hold is seen as the first press followed by
+// autorepeat, and the initial repeat delay can be well over half a second.
+const holdGap = 700 * time.Millisecond
+
+// repromptDelay is how long a guided prompt waits for its key before it is
+// shown again
+const repromptDelay = 5 * time.Second
+
+// guidedTimeout is posted repromptDelay after prompt pos was shown
+type guidedTimeout struct{ pos int }
+
+// guided prompts each reportable key of the layout in order and waits for
+// it to be pressed
+type guided struct {
+	keys   []Key
+	order  []int // indices into keys, in prompt order
+	pos    int
+	wrong  int
+	layout string // built-in layout in use, empty for loaded layouts
+
+	// hold is how long the expected key must be held to count, zero to
+	// accept a single press
+	hold                time.Duration
+	holdStart, holdLast time.Time
+
+	// evidence records, per other built-in layout, the key positions whose
+	// wrong press matched that layout
+	evidence map[string]map[int]bool
+	suggest  string
+
+	seed int64 // shuffle seed, 0 if the order was not shuffled
+
+	// early is set while the last press was the key after the expected
+	// one; the expected key next makes it a transposition. swapped holds
+	// the keys of every transposition.
+	early      bool
+	transposed int
+	swapped    []string
+
+	// maxAttempts prompts without a press fail a key, zero for no limit;
+	// attempts counts those of the current key and alarm is the banner for
+	// a key that just failed
+	maxAttempts int
+	attempts    int
+	failed      []string
+	alarm       string
+}
+
+// newGuided returns nil if the layout has no key a terminal can report
+func newGuided(keys []Key, layout string) *guided {
+	g := &guided{keys: keys, layout: layout, evidence: map[string]map[int]bool{}}
+	for _, i := range uniqueKeys(keys) {
+		if reportable(keys[i].Label) {
+			g.order = append(g.order, i)
+		}
+	}
+	if len(g.order) == 0 {
+		return nil
+	}
+	return g
+}
+
+// shuffle randomises the prompt order. The same seed gives the same order
+// for the same layout.
+func (g *guided) shuffle(seed int64) {
+	g.seed = seed
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(g.order), func(i, j int) {
+		g.order[i], g.order[j] = g.order[j], g.order[i]
+	})
+}
+
+// expected returns the label to press next
+func (g *guided) expected() string {
+	if g.done() {
+		return ""
+	}
+	return g.keys[g.order[g.pos]].Label
+}
+
+// press checks one key press at time at and returns the log line for it,
+// if any
+func (g *guided) press(label string, at time.Time) string {
+	want := g.expected()
+	if want == "" {
+		return ""
+	}
+	if label == want {
+		if g.hold == 0 {
+			if g.early {
+				// both keys are down, just in the wrong order
+				g.early = false
+				g.wrong--
+				g.transposed++
+				next := g.keys[g.order[g.pos+1]].Label
+				g.swapped = append(g.swapped, want, next)
+				g.pos += 2
+				g.attempts = 0
+				return fmt.Sprintf("guided: TRANSPOSED %s and %s, pressed %s first", want, next, next)
+			}
+			g.pos++
+			g.attempts = 0
+			return ""
+		}
+		if g.holdStart.IsZero() || at.Sub(g.holdLast) > holdGap {
+			g.holdStart = at
+		}
+		g.holdLast = at
+		if held := at.Sub(g.holdStart); held >= g.hold {
+			g.pos++
+			g.attempts = 0
+			g.holdStart = time.Time{}
+			return fmt.Sprintf("guided: %s held for %v", want, held.Round(time.Millisecond))
+		}
+		return ""
+	}
+	g.holdStart = time.Time{}
+	g.early = g.hold == 0 && g.pos+1 < len(g.order) && label == g.keys[g.order[g.pos+1]].Label
+	g.wrong++
+	g.checkSwap(g.order[g.pos], label)
+	return fmt.Sprintf("guided: expected %s, got %s", want, label)
+}
+
+// reprompt counts a prompt that went repromptDelay without its key at time
+// at and shows it again. After maxAttempts of them the key is probably
+// dead: it fails and the next key is prompted. It returns the log line.
+func (g *guided) reprompt(at time.Time) string {
+	want := g.expected()
+	if want == "" || g.hold > 0 && at.Sub(g.holdLast) <= holdGap {
+		return "" // nothing to prompt, or the key is being held
+	}
+	if g.attempts++; g.maxAttempts > 0 && g.attempts >= g.maxAttempts {
+		g.failed = append(g.failed, want)
+		g.alarm = fmt.Sprintf("DEAD KEY? %s did not register in %d prompts", want, g.attempts)
+		g.pos++
+		g.attempts, g.early = 0, false
+		g.holdStart = time.Time{}
+		return fmt.Sprintf("guided: no press of %s after %d prompts; %s FAILED", want, g.maxAttempts, want)
+	}
+	return fmt.Sprintf("guided: still waiting for %s, prompt %d", want, g.attempts+1)
+}
+
+// checkSwap looks for another built-in layout that has the pressed label at
+// the prompted position. Once enough positions agree on one layout it is
+// suggested, as the board or OS keymap most likely differs from ours.
+func (g *guided) checkSwap(idx int, got string) {
+	if g.layout == "" || g.suggest != "" {
+		return
+	}
+	for _, name := range layoutNames() {
+		if name == g.layout {
+			continue
+		}
+		other := initKeys(name, false)
+		if idx >= len(other) || other[idx].Label != got {
+			continue
+		}
+		if g.evidence[name] == nil {
+			g.evidence[name] = map[int]bool{}
+		}
+		g.evidence[name][idx] = true
+		if len(g.evidence[name]) >= swapEvidence {
+			g.suggest = name
+			return
+		}
+	}
+}
+
+// progress returns how far the current hold is towards confirming the
+// expected key, from 0 to 1
+func (g *guided) progress(now time.Time) float64 {
+	if g.hold == 0 || g.holdStart.IsZero() || now.Sub(g.holdLast) > holdGap {
+		return 0
+	}
+	return min(float64(g.holdLast.Sub(g.holdStart))/float64(g.hold), 1)
+}
+
+// warning is the banner text for a suspected layout mismatch
+func (g *guided) warning() string {
+	if g.suggest == "" {
+		return ""
+	}
+	return fmt.Sprintf("LAYOUT: presses match the %q layout, try -layout %s", g.suggest, g.suggest)
+}
+
+// takeAlarm returns the alarm for a newly failed key, once
+func (g *guided) takeAlarm() string {
+	a := g.alarm
+	g.alarm = ""
+	return a
+}
+
+func (g *guided) done() bool {
+	return g.pos >= len(g.order)
+}
+
+// status is the prompt shown on screen
+func (g *guided) status() string {
+	if g.done() {
+		return "GUIDED: complete"
+	}
+	if g.hold > 0 {
+		return fmt.Sprintf("GUIDED %d/%d: hold %s", g.pos+1, len(g.order), g.expected())
+	}
+	return fmt.Sprintf("GUIDED %d/%d: press %s", g.pos+1, len(g.order), g.expected())
+}
+
+// result summarises the run for the exit report
+func (g *guided) result() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "guided: %d/%d keys pressed, %d wrong presses, %d transpositions", g.pos, len(g.order), g.wrong, g.transposed)
+	if g.seed != 0 {
+		fmt.Fprintf(&b, " (random order, -guided-seed %d)", g.seed)
+	}
+	if len(g.failed) > 0 {
+		fmt.Fprintf(&b, "; failed after %d prompts: %s", g.maxAttempts, strings.Join(g.failed, " "))
+	}
+	if g.suggest != "" {
+		fmt.Fprintf(&b, "; presses matched the %q layout, try -layout %s", g.suggest, g.suggest)
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// keycapMark follows a digit, # or * (and the emoji presentation selector)
+// to form its keycap emoji
+const keycapMark = '\u20e3'
+
+func isKeycap(label string) bool {
+	return len(label) == 1 && strings.Contains("0123456789#*", label)
+}
+
+// drawKeycap draws the keycap emoji for r at x,y. Terminals show it two
+// cells wide but tcell sizes a cell by its base rune alone, so the second
+// cell gets a zero-width space: it keeps tcell's idea of the cursor in step
+// with the terminal's, and the rest of the row stays aligned.
+func drawKeycap(s tcell.Screen, x, y int, r rune, style tcell.Style) {
+	s.SetContent(x, y, r, []rune{'\ufe0f', keycapMark}, style)
+	s.SetContent(x+1, y, '\u200b', nil, style)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+)
+
+// kleColors are the key colours of an -export-kle file
+const (
+	kleTested   = "#6fa8dc"
+	kleUntested = "#cccccc"
+)
+
+// exportKLE writes the keys to path as keyboard-layout-editor raw data, one
+// array per row, with tested keys coloured kleTested
+func exportKLE(path string, keys []Key, pressed map[string]bool) error {
+	rows := map[int][]Key{}
+	var ys []int
+	x0, y0 := math.MaxInt, math.MaxInt
+	for _, k := range keys {
+		if _, ok := rows[k.Y]; !ok {
+			ys = append(ys, k.Y)
+		}
+		rows[k.Y] = append(rows[k.Y], k)
+		x0, y0 = min(x0, k.X), min(y0, k.Y)
+	}
+	sort.Ints(ys)
+
+	unitsX := func(c int) float64 { return math.Round(float64(c)/cellsPerUnitX*100) / 100 }
+	unitsY := func(c int) float64 { return math.Round(float64(c)/cellsPerUnitY*100) / 100 }
+	var out [][]any
+	color, y := "", 0.0
+	for _, ky := range ys {
+		row := rows[ky]
+		sort.Slice(row, func(i, j int) bool { return row[i].X < row[j].X })
+		var items []any
+		x := 0.0
+		for i, k := range row {
+			// KLE keeps the colour for the following keys but resets the
+			// size after every key and the position after every row
+			props := map[string]any{}
+			c := kleUntested
+			if pressed[k.Label] {
+				c = kleTested
+			}
+			if c != color {
+				props["c"], color = c, c
+			}
+			if i == 0 {
+				if dy := unitsY(ky-y0) - y; dy != 0 {
+					props["y"] = dy
+				}
+			}
+			kx := unitsX(k.X - x0)
+			if dx := kx - x; dx != 0 {
+				props["x"] = dx
+			}
+			w, h := unitsX(k.W+1), unitsY(k.H+1)
+			if w != 1 {
+				props["w"] = w
+			}
+			if h != 1 {
+				props["h"] = h
+			}
+			if len(props) > 0 {
+				items = append(items, props)
+			}
+			items = append(items, k.Label)
+			x = kx + w
+		}
+		out = append(out, items)
+		y = unitsY(ky-y0) + 1
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
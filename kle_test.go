@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExportKLE(t *testing.T) {
+	// offset by 2,1 cells: the export starts at the top-left key
+	keys := []Key{
+		{Label: "Tab", X: 2, Y: 5, W: 5, H: 3},
+		{Label: "Esc", X: 2, Y: 1, W: 3, H: 3},
+		{Label: "1", X: 10, Y: 1, W: 3, H: 3},
+		{Label: "Enter", X: 12, Y: 5, W: 3, H: 7},
+		{Label: "Q", X: 8, Y: 5, W: 3, H: 3},
+		{Label: "Space", X: 2, Y: 13, W: 23, H: 3},
+	}
+	pressed := map[string]bool{"Esc": true, "Q": true}
+	want := `[
+		[{"c": "#6fa8dc"}, "Esc", {"c": "#cccccc", "x": 1}, "1"],
+		[{"w": 1.5}, "Tab", {"c": "#6fa8dc"}, "Q", {"c": "#cccccc", "h": 2}, "Enter"],
+		[{"y": 1, "w": 6}, "Space"]
+	]`
+
+	path := filepath.Join(t.TempDir(), "kle.json")
+	if err := exportKLE(path, keys, pressed); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, wantRows any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantRows); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wantRows) {
+		t.Errorf("exportKLE wrote\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestExportKLEReadsBackWithVIA(t *testing.T) {
+	// KLE raw data is the keymap of a VIA definition, so loadVIA places
+	// the keys where they were exported from
+	keys := initKeys("us", false)
+	path := filepath.Join(t.TempDir(), "kle.json")
+	if err := exportKLE(path, keys, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := filepath.Join(t.TempDir(), "via.json")
+	if err := os.WriteFile(def, []byte(`{"layouts": {"keymap": `+string(data)+`}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	back, err := loadVIA(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != len(keys) {
+		t.Fatalf("read back %d keys, want %d", len(back), len(keys))
+	}
+	// same keys, positions relative to the top-left one
+	x0, y0 := keys[0].X, keys[0].Y
+	for _, k := range keys {
+		x0, y0 = min(x0, k.X), min(y0, k.Y)
+	}
+	want := map[Key]int{}
+	for _, k := range keys {
+		k.X, k.Y = k.X-x0, k.Y-y0
+		want[Key{Label: k.Label, X: k.X, Y: k.Y, W: k.W, H: k.H}]++
+	}
+	for _, b := range back {
+		b = Key{Label: b.Label, X: b.X, Y: b.Y, W: b.W, H: b.H}
+		if want[b] == 0 {
+			t.Errorf("read back %+v, not in the layout", b)
+			continue
+		}
+		want[b]--
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// minRightPane is the narrowest right pane worth splitting the screen for
+const minRightPane = 30
+
+// minLogRows is the log space kept free when -pan shrinks the keyboard
+const minLogRows = 3
+
+// modCombos is the number of ways to hold Ctrl, Alt and Shift, none included
+const modCombos = 8
+
+// panes is where drawAll puts each part of the screen below or beside the
+// keyboard
+type panes struct {
+	split                  bool // stats and log are right of the keyboard
+	divX                   int  // vertical divider column when split
+	statsX, statsY, statsW int
+	statsRows              int
+	sepX, sepY, sepW, sepH int
+	logX, logY, logW, logH int
+	viewW, viewH           int // visible part of the keyboard
+}
+
+// panes lays out the screen for a w by h terminal. In dual-pane mode the
+// stats and log move to the right of the keyboard, one stat per row, as
+// long as the terminal is wide enough. With panning enabled a keyboard too
+// tall for the terminal is cut down to leave room for a few log rows.
+func (v *view) panes(w, h int) panes {
+	stats := v.stats()
+	sepH := max(v.sep.rows, 1)
+	right := keyboardRight(v.keys)
+	if v.dual && w-right-2 >= minRightPane {
+		p := panes{split: true, divX: right + 1, viewW: right + 1, viewH: h}
+		x := right + 3
+		p.statsX, p.statsY, p.statsW, p.statsRows = x, 0, w-x, len(stats)
+		p.sepX, p.sepY, p.sepW, p.sepH = x-1, len(stats), w-x+1, sepH
+		p.logX, p.logY, p.logW, p.logH = x, p.sepY+sepH, w-x, h-p.sepY-sepH
+		return p
+	}
+
+	bottom := keyboardBottom(v.keys)
+	if avail := h - 1 - sepH - minLogRows; v.pan && bottom > avail {
+		bottom = max(avail, 0)
+	}
+	p := panes{statsW: w, statsY: bottom, statsRows: 1, viewW: w, viewH: bottom}
+	p.sepY, p.sepW, p.sepH = bottom+1, w, sepH
+	p.logY, p.logW, p.logH = p.sepY+sepH, w, h-p.sepY-sepH
+	return p
+}
+
+// separator is how the line between the stats and the log is drawn
+type separator struct {
+	char    rune // '-' if unset
+	rows    int  // 1 if unset
+	caption string
+}
+
+// separatorChars are the -separator-style choices
+var separatorChars = map[string]rune{
+	"dash":   '-',
+	"line":   tcell.RuneHLine,
+	"double": '═',
+	"thick":  '━',
+}
+
+// stats returns the lines of the stats panel
+func (v *view) stats() []string {
+	tested, total := coverage(v.keys, v.pressed)
+	pct := 0
+	if total > 0 {
+		pct = tested * 100 / total
+	}
+	lines := []string{
+		fmt.Sprintf("Tested: %d/%d keys (%d%%)", tested, total, pct),
+		fmt.Sprintf("Events: %d", v.events),
+		fmt.Sprintf("Modifiers: %d/%d combos", len(v.combos), modCombos),
+	}
+	if label, n := hottest(v.counts); n > 0 {
+		lines = append(lines, fmt.Sprintf("Hottest key: %s (%d)", label, n))
+	}
+	if v.cases != nil {
+		both := 0
+		letters := caseLetters(v.keys)
+		for _, label := range letters {
+			if v.cases[label] == caseLower|caseUpper {
+				both++
+			}
+		}
+		lines = append(lines, fmt.Sprintf("Both cases: %d/%d letters", both, len(letters)))
+	}
+	if len(v.mismatched) > 0 {
+		lines = append(lines, fmt.Sprintf("Code mismatches: %d", len(v.mismatched)))
+	}
+	if v.idleThreshold > 0 {
+		now := time.Now()
+		elapsed, idle := now.Sub(v.start), v.idleAt(now)
+		lines = append(lines, fmt.Sprintf("Elapsed: %v, idle: %v, active: %v",
+			elapsed.Truncate(time.Second), idle.Truncate(time.Second), (elapsed-idle).Truncate(time.Second)))
+	}
+	if v.base != nil {
+		added, missing := sessionDiff(v.keys, v.base, v.pressed)
+		lines = append(lines, fmt.Sprintf("Baseline: +%d new, %d not yet seen", len(added), len(missing)))
+	}
+	return lines
+}
+
+// hottest returns the most pressed key, the first by label on a tie
+func hottest(counts map[string]int) (string, int) {
+	best, most := "", 0
+	for label, n := range counts {
+		if n > most || n == most && label < best {
+			best, most = label, n
+		}
+	}
+	return best, most
+}
+
+// statsLine joins the stats for the single row used below the keyboard
+func statsLine(stats []string) string {
+	return strings.Join(stats, " | ")
+}
+
+// uniqueKeys returns the index of the first key with each label, in
+// layout order. Labels such as Shift or Ctrl can appear more than once.
+func uniqueKeys(keys []Key) []int {
+	var out []int
+	seen := map[string]bool{}
+	for i, k := range keys {
+		if !seen[k.Label] {
+			seen[k.Label] = true
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// coverage counts the distinct layout labels pressed so far
+func coverage(keys []Key, pressed map[string]bool) (tested, total int) {
+	for _, i := range uniqueKeys(keys) {
+		total++
+		if pressed[keys[i].Label] {
+			tested++
+		}
+	}
+	return tested, total
+}
+
+// keyboardRight returns the first column right of the keyboard, including
+// the right border of any cluster box
+func keyboardRight(keys []Key) int {
+	right := 0
+	for _, k := range keys {
+		r := k.X + k.W
+		if k.Cluster != "" {
+			r++
+		}
+		if r > right {
+			right = r
+		}
+	}
+	return right
+}
+
+// clampPan keeps the pan offset within the keyboard for the visible area
+func (v *view) clampPan(p panes) {
+	v.ox = max(min(v.ox, keyboardRight(v.keys)-p.viewW), 0)
+	v.oy = max(min(v.oy, keyboardBottom(v.keys)-p.viewH), 0)
+}
+
+// viewport draws onto the keyboard part of a screen, shifted by the pan
+// offset and clipped to the visible area
+type viewport struct {
+	tcell.Screen
+	ox, oy, w, h int
+}
+
+func (vp viewport) SetContent(x, y int, mainc rune, combc []rune, style tcell.Style) {
+	x, y = x-vp.ox, y-vp.oy
+	if x < 0 || y < 0 || x >= vp.w || y >= vp.h {
+		return
+	}
+	vp.Screen.SetContent(x, y, mainc, combc, style)
+}
+
+// minFitKeyWidth is the narrowest -auto-fit lets a key get, in cells
+const minFitKeyWidth = 2
+
+// fitScale returns the factor that shrinks keys to fit in width columns, 1
+// if they already fit. ok is false if that would make the narrowest key
+// thinner than minFitKeyWidth.
+func fitScale(keys []Key, width int) (f float64, ok bool) {
+	right := keyboardRight(keys)
+	if right <= width {
+		return 1, true
+	}
+	x0, end, narrowest := right, 0, right
+	for _, k := range keys {
+		x0 = min(x0, k.X)
+		end = max(end, k.X+k.W)
+		narrowest = min(narrowest, k.W)
+	}
+	// the cluster border right of the last key does not shrink
+	f = float64(width-x0-(right-end)) / float64(end-x0)
+	return f, f*float64(narrowest) >= float64(min(minFitKeyWidth, narrowest))
+}
+
+// fitKeys scales key positions and widths by f, keeping the left edge of
+// the layout in place. Positions are rounded down, so keys never overlap
+// though the gaps between them may close.
+func fitKeys(keys []Key, f float64) []Key {
+	if f >= 1 {
+		return keys
+	}
+	x0 := keyboardRight(keys)
+	for _, k := range keys {
+		x0 = min(x0, k.X)
+	}
+	scale := func(x int) int { return x0 + int(float64(x-x0)*f) }
+	out := make([]Key, len(keys))
+	for i, k := range keys {
+		k.X, k.W = scale(k.X), scale(k.X+k.W)-scale(k.X)
+		out[i] = k
+	}
+	return out
+}
+
+// fit rebuilds the drawn keys from the unscaled layout for a terminal w
+// columns wide, when -auto-fit is on
+func (v *view) fit(w int) {
+	if v.layout == nil {
+		return
+	}
+	f, ok := fitScale(v.layout, w)
+	v.tooSmall = !ok
+	if ok {
+		v.keys = fitKeys(v.layout, f)
+	}
+}
+
+// keyAt returns the index of the key covering keyboard cell x,y, or -1
+func keyAt(keys []Key, x, y int) int {
+	for i, k := range keys {
+		if x >= k.X && x < k.X+k.W && y >= k.Y && y < k.Y+k.H {
+			return i
+		}
+	}
+	return -1
+}
+
+// tooltip describes the presses of a key for the -hover tooltip
+func (v *view) tooltip(label string, now time.Time) string {
+	n := v.counts[label]
+	if n == 0 {
+		return label + ": never pressed"
+	}
+	last, ok := v.lastPress[label]
+	if !ok { // counts seeded by -load-counts carry no press time
+		return fmt.Sprintf("%s: %d presses", label, n)
+	}
+	return fmt.Sprintf("%s: %d presses, last: %s, %v ago", label, n,
+		last.Format("15:04:05"), now.Sub(last).Round(100*time.Millisecond))
+}
+
+// hintPulse is how often the -hint key blinks
+const hintPulse = 500 * time.Millisecond
+
+// hintTick is posted every hintPulse while -hint is on
+type hintTick struct{}
+
+// nextHint returns the first untested layout key after current, wrapping
+// around, or "" once every key has been tested
+func nextHint(keys []Key, pressed map[string]bool, current string) string {
+	var untested []string
+	after := -1
+	for _, i := range uniqueKeys(keys) {
+		k := keys[i]
+		if k.Label == current {
+			after = len(untested)
+		}
+		if !pressed[k.Label] {
+			untested = append(untested, k.Label)
+		}
+	}
+	if len(untested) == 0 {
+		return ""
+	}
+	if after >= 0 && after < len(untested) && untested[after] != current {
+		return untested[after]
+	}
+	return untested[(after+1)%len(untested)]
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestFitScale(t *testing.T) {
+	two := []Key{{Label: "A", W: 3}, {Label: "B", X: 4, W: 3}}
+	tests := []struct {
+		name  string
+		keys  []Key
+		width int
+		want  float64
+		ok    bool
+	}{
+		{"fits", two, 10, 1, true},
+		{"exact fit", two, 7, 1, true},
+		{"shrinks", two, 5, 5.0 / 7, true},
+		{"too narrow", two, 2, 2.0 / 7, false},
+		{"left margin stays", []Key{{Label: "A", X: 2, W: 3}, {Label: "B", X: 6, W: 3}}, 7, 5.0 / 7, true},
+		{"cluster border stays", []Key{{Label: "A", W: 3, Cluster: "c"}, {Label: "B", X: 4, W: 3, Cluster: "c"}}, 6, 5.0 / 7, true},
+		{"one-cell keys cannot shrink", []Key{{Label: "A", W: 1}, {Label: "B", X: 2, W: 1}}, 2, 2.0 / 3, false},
+	}
+	for _, tt := range tests {
+		f, ok := fitScale(tt.keys, tt.width)
+		if math.Abs(f-tt.want) > 1e-9 || ok != tt.ok {
+			t.Errorf("%s: fitScale(width %d) = %v, %v, want %v, %v", tt.name, tt.width, f, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFitKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []Key
+		f    float64
+		want []Key
+	}{
+		{"unscaled", []Key{{Label: "A", X: 3, W: 3}}, 1, []Key{{Label: "A", X: 3, W: 3}}},
+		{"half", []Key{{Label: "A", X: 2, W: 4}, {Label: "B", X: 10, W: 4}}, 0.5,
+			[]Key{{Label: "A", X: 2, W: 2}, {Label: "B", X: 6, W: 2}}},
+		{"rounds down without overlap", []Key{{Label: "A", W: 3}, {Label: "B", X: 4, W: 3}}, 0.7,
+			[]Key{{Label: "A", W: 2}, {Label: "B", X: 2, W: 2}}},
+		{"rows and heights stay", []Key{{Label: "A", Y: 4, W: 4, H: 3}}, 0.5, []Key{{Label: "A", Y: 4, W: 2, H: 3}}},
+	}
+	for _, tt := range tests {
+		if got := fitKeys(tt.keys, tt.f); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: fitKeys(%v) = %+v, want %+v", tt.name, tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestFitKeysFitsWidth(t *testing.T) {
+	keys := initKeys("us", true)
+	for w := 40; w <= keyboardRight(keys); w++ {
+		f, ok := fitScale(keys, w)
+		if !ok {
+			continue
+		}
+		if right := keyboardRight(fitKeys(keys, f)); right > w {
+			t.Errorf("width %d: fitted keyboard is %d columns wide", w, right)
+		}
+	}
+}
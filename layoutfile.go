@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// layoutFileKey is one key of a -layout-file. Positions and sizes are in
+// terminal cells like Key; W and H default to fit the label.
+type layoutFileKey struct {
+	Label   string   `json:"label" yaml:"label"`
+	X       int      `json:"x" yaml:"x"`
+	Y       int      `json:"y" yaml:"y"`
+	W       int      `json:"w,omitempty" yaml:"w,omitempty"`
+	H       int      `json:"h,omitempty" yaml:"h,omitempty"`
+	Cluster string   `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Matrix  *[2]int  `json:"matrix,omitempty" yaml:"matrix,omitempty"` // [row, col]
+	Code    *keyCode `json:"code,omitempty" yaml:"code,omitempty"`     // expected tcell key code
+}
+
+// keyCode is a tcell key code given as a number or a key name such as
+// "Rune", "Enter" or "F5"
+type keyCode int
+
+func (c *keyCode) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("code must be a number or key name")
+		}
+		*c = keyCode(n)
+		return nil
+	}
+	k, ok := keyByName(name)
+	if !ok {
+		return fmt.Errorf("unknown key name %q", name)
+	}
+	*c = keyCode(k)
+	return nil
+}
+
+func (c *keyCode) UnmarshalYAML(value *yaml.Node) error {
+	var n int
+	if err := value.Decode(&n); err == nil {
+		*c = keyCode(n)
+		return nil
+	}
+	k, ok := keyByName(value.Value)
+	if !ok {
+		return fmt.Errorf("line %d: unknown key name %q", value.Line, value.Value)
+	}
+	*c = keyCode(k)
+	return nil
+}
+
+// keyByName looks up a tcell key by its name, or parses a number
+func keyByName(name string) (tcell.Key, bool) {
+	if name == "Rune" {
+		return tcell.KeyRune, true
+	}
+	for k, n := range tcell.KeyNames {
+		if n == name {
+			return k, true
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		return tcell.Key(n), true
+	}
+	return 0, false
+}
+
+// loadLayoutFile reads a list of keys, as YAML if the file is named
+// .yaml or .yml and as a JSON array otherwise
+func loadLayoutFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fileKeys []layoutFileKey
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &fileKeys)
+	default:
+		err = json.Unmarshal(data, &fileKeys)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	keys := fileLayoutKeys(fileKeys)
+	if err := validateLayout(keys); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return keys, nil
+}
+
+// fileLayoutKeys converts layout file entries to keys, filling in defaults
+func fileLayoutKeys(fileKeys []layoutFileKey) []Key {
+	keys := make([]Key, len(fileKeys))
+	for i, fk := range fileKeys {
+		k := Key{Label: fk.Label, X: fk.X, Y: fk.Y, W: fk.W, H: fk.H, Cluster: fk.Cluster}
+		if k.W == 0 {
+			k.W = utf8.RuneCountInString(k.Label) + 2
+		}
+		if k.H == 0 {
+			k.H = 3
+		}
+		if fk.Matrix != nil {
+			k.Row, k.Col, k.HasMatrix = fk.Matrix[0], fk.Matrix[1], true
+		}
+		if fk.Code != nil {
+			k.Code, k.HasCode = tcell.Key(*fk.Code), true
+		}
+		keys[i] = k
+	}
+	return keys
+}
+
+// validateLayout rejects layouts that cannot be drawn sensibly
+func validateLayout(keys []Key) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("layout has no keys")
+	}
+	for i, k := range keys {
+		switch {
+		case k.Label == "":
+			return fmt.Errorf("key %d has no label", i+1)
+		case k.X < 0 || k.Y < 0:
+			return fmt.Errorf("key %d (%s) has a negative position", i+1, k.Label)
+		case k.W < 1 || k.H < 1:
+			return fmt.Errorf("key %d (%s) has no size", i+1, k.Label)
+		}
+		for j := 0; j < i; j++ {
+			o := keys[j]
+			if k.X < o.X+o.W && o.X < k.X+k.W && k.Y < o.Y+o.H && o.Y < k.Y+k.H {
+				return fmt.Errorf("key %d (%s) overlaps key %d (%s)", i+1, k.Label, j+1, o.Label)
+			}
+		}
+	}
+	return nil
+}
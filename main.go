@@ -1,176 +1,1377 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
 )
 
 // Key represents a key on the keyboard
 type Key struct {
 	Label      string
 	X, Y, W, H int
+	Cluster    string // optional group boxed by drawAll
+	Row, Col   int    // switch matrix position, valid if HasMatrix
+	HasMatrix  bool
+	Code       tcell.Key // expected key code, checked if HasCode
+	HasCode    bool
+}
+
+// config holds the command line options
+type config struct {
+	ExpectSequence  string
+	ExpectWindow    int
+	Clusters        bool
+	CoalesceMods    time.Duration
+	Watchdog        time.Duration
+	WatchdogFail    bool
+	VIA             string
+	Reaction        int
+	NoCapsLock      bool
+	LogMatrix       bool
+	DualPane        bool
+	Piano           bool
+	PianoMap        string
+	AudioCmd        string
+	Layout          string
+	Guided          bool
+	NoTimestamps    bool
+	SaveSession     string
+	Baseline        string
+	Paste           string
+	IdleThreshold   time.Duration
+	GridView        bool
+	GuidedHold      time.Duration
+	SQLite          string
+	Pan             bool
+	GuidedRandom    bool
+	GuidedSeed      int64
+	LayoutFile      string
+	Forbid          string
+	AutoFit         bool
+	RepeatThreshold time.Duration
+	OnComplete      string
+	ExportKLE       string
+	SepStyle        string
+	SepRows         int
+	SepCaption      string
+	Typing          string
+	TypingWPM       float64
+	TypingAccuracy  float64
+	CaseSensitive   bool
+	MetaAsAlt       bool
+	Keycaps         bool
+	LogResolved     bool
+	StatusFile      string
+	Rumble          bool
+	ExitHold        time.Duration
+	Hover           bool
+	StartupSync     time.Duration
+	EvdevLog        string
+	Hint            time.Duration
+	ExitSequence    string
+	ResetKey        string
+	Theme           string
+	Animation       string
+	GuidedMax       int
+	LoadCounts      string
+	Rotate          time.Duration
+	RotateDir       string
 }
 
 func main() {
+	var cfg config
+	flag.StringVar(&cfg.ExpectSequence, "expect-sequence", "", "exit 0 if the keys in `file` are pressed in order, 1 on the first mismatch")
+	flag.IntVar(&cfg.ExpectWindow, "expect-window", 0, "number of later steps an -expect-sequence key may match out of order")
+	flag.BoolVar(&cfg.Clusters, "clusters", false, "draw labelled boxes around the key clusters")
+	flag.DurationVar(&cfg.CoalesceMods, "coalesce-mods", 0, "drop a modifier-only event from the log if the modified key follows within `window`")
+	flag.DurationVar(&cfg.Watchdog, "watchdog", 0, "warn if no key is pressed within `window` of starting")
+	flag.BoolVar(&cfg.WatchdogFail, "watchdog-fail", false, "exit 1 when the -watchdog window passes without a key")
+	flag.StringVar(&cfg.VIA, "via", "", "load the layout from a VIA/VIAL keyboard definition `file`")
+	flag.IntVar(&cfg.Reaction, "reaction", 0, "run a reaction-time test of `rounds` random keys")
+	flag.BoolVar(&cfg.NoCapsLock, "no-capslock-heuristic", false, "do not light CapsLock for unshifted uppercase letters")
+	flag.BoolVar(&cfg.LogMatrix, "log-matrix", false, "add the switch matrix row,col of the key to each log line")
+	flag.BoolVar(&cfg.DualPane, "dual-pane", false, "show the stats and log right of the keyboard when the terminal is wide enough")
+	flag.BoolVar(&cfg.Piano, "piano", false, "play a note for every key press")
+	flag.StringVar(&cfg.PianoMap, "piano-map", "", "read LABEL=NOTE overrides for -piano from `file`")
+	flag.StringVar(&cfg.AudioCmd, "audio-cmd", defaultPlayer, "`command` that plays 16-bit mono 44.1kHz PCM from stdin")
+	flag.StringVar(&cfg.Layout, "layout", "us", "built-in `layout`: "+strings.Join(layoutNames(), ", "))
+	flag.BoolVar(&cfg.Guided, "guided", false, "prompt every key of the layout in turn")
+	flag.BoolVar(&cfg.NoTimestamps, "no-timestamps", false, "leave the time out of log lines")
+	flag.StringVar(&cfg.SaveSession, "save-session", "", "write per-key counts and coverage to `file` on exit")
+	flag.StringVar(&cfg.Baseline, "baseline", "", "compare against a session `file` saved earlier")
+	flag.StringVar(&cfg.Paste, "paste", "keys", "bracketed paste `policy`: keys (treat as key presses), ignore, or mark (log but do not count)")
+	flag.DurationVar(&cfg.IdleThreshold, "idle-threshold", 0, "show elapsed and idle time, counting gaps between keys longer than `duration` as idle")
+	flag.BoolVar(&cfg.GridView, "grid-view", false, "draw every key as a single cell for a compact overview")
+	flag.DurationVar(&cfg.GuidedHold, "guided-hold", 0, "in -guided mode, require each key to be held for `duration` (an unbroken run of autorepeat)")
+	flag.StringVar(&cfg.SQLite, "sqlite", "", "record every key event in the SQLite database `file`")
+	flag.BoolVar(&cfg.Pan, "pan", false, "let a keyboard larger than the terminal scroll with Shift+arrows")
+	flag.BoolVar(&cfg.GuidedRandom, "guided-random", false, "prompt the -guided keys in random order")
+	flag.Int64Var(&cfg.GuidedSeed, "guided-seed", 0, "`seed` for -guided-random, 0 picks one (printed on exit)")
+	flag.StringVar(&cfg.LayoutFile, "layout-file", "", "load the layout from a JSON or YAML (.yaml, .yml) `file` of keys, optionally with expected codes")
+	flag.StringVar(&cfg.Forbid, "forbid", "", "comma-separated `keys` that must not exist, e.g. \"F13,Ctrl+Q\"; pressing one fails the run")
+	flag.BoolVar(&cfg.AutoFit, "auto-fit", false, "shrink keys and the gaps between them so the keyboard fits the terminal width")
+	flag.DurationVar(&cfg.RepeatThreshold, "repeat-threshold", 100*time.Millisecond, "mark a key's event as \"(repeat)\" in the log if it follows its previous event within `gap`, 0 to never mark")
+	flag.StringVar(&cfg.OnComplete, "on-complete", "continue", "what to do once every key is tested: continue, banner (keep running), stop, or reset (start over, for unattended displays)")
+	flag.StringVar(&cfg.ExportKLE, "export-kle", "", "on exit, write the layout with tested keys coloured to `file` as keyboard-layout-editor JSON")
+	flag.StringVar(&cfg.SepStyle, "separator-style", "dash", "`style` of the line above the log: dash, line, double or thick")
+	flag.IntVar(&cfg.SepRows, "separator-rows", 1, "height of the separator above the log in `rows`")
+	flag.StringVar(&cfg.SepCaption, "separator-caption", "", "`text` centered on the separator, e.g. \"LOG\"")
+	flag.StringVar(&cfg.Typing, "typing", "", "run a typing test of the text in `file`")
+	flag.Float64Var(&cfg.TypingWPM, "typing-wpm", 0, "in a -typing test, fail below `wpm` words per minute")
+	flag.Float64Var(&cfg.TypingAccuracy, "typing-accuracy", 0, "in a -typing test, fail below `percent` correct keystrokes")
+	flag.BoolVar(&cfg.CaseSensitive, "case-sensitive", false, "track lower and upper case letters separately and report which were typed in both")
+	flag.BoolVar(&cfg.MetaAsAlt, "meta-as-alt", false, "count Meta as Alt and map macOS Option characters (å, ß, ∂, ...) of the us layout to their keys, for Mac terminals")
+	flag.BoolVar(&cfg.Keycaps, "keycaps", false, "draw digit, # and * keys as emoji keycaps (1️⃣) if the terminal can show them")
+	flag.BoolVar(&cfg.LogResolved, "log-resolved", false, "add the layout key each event was matched to, or <none>, to each log line")
+	flag.StringVar(&cfg.StatusFile, "status-file", "", "keep the current coverage in `file` as JSON, rewritten whenever it changes")
+	flag.BoolVar(&cfg.Rumble, "rumble", false, "pulse the rumble of a connected game controller on every key press (needs -tags rumble)")
+	flag.DurationVar(&cfg.Rotate, "rotate", 0, "every `interval` and at exit, write a timestamped session report and restart the key counters; guided, typing and -expect-sequence progress carries on")
+	flag.StringVar(&cfg.RotateDir, "rotate-dir", ".", "`directory` for the -rotate reports")
+	flag.DurationVar(&cfg.ExitHold, "exit-hold", 0, "count an Esc, Enter or Space press towards exit only once held for `duration`, ignoring brief taps")
+	flag.BoolVar(&cfg.Hover, "hover", false, "show the press count and last press time of the key under the mouse, and the keys sharing its matrix row or column")
+	flag.DurationVar(&cfg.StartupSync, "startup-sync", 0, "wait up to `timeout` for the terminal size to settle before the first draw")
+	flag.StringVar(&cfg.EvdevLog, "evdev-log", "", "write key events to `file` as evdev-style lines (time, type, code, value)")
+	flag.DurationVar(&cfg.Hint, "hint", 0, "blink one untested key at a time, moving to the next every `interval`")
+	flag.StringVar(&cfg.ExitSequence, "exit-sequence", "", "exit only when the space-separated `keys` are pressed in a row, e.g. \"q u i t\", instead of five Esc, Enter or Space presses")
+	flag.StringVar(&cfg.ResetKey, "reset-key", "", "`key` that throws away all state and starts over as if just launched, e.g. \"Ctrl+R\"")
+	flag.StringVar(&cfg.Theme, "theme", "classic", "`theme` for tested keys and press animation: "+strings.Join(themeNames(), ", "))
+	flag.StringVar(&cfg.Animation, "animation", "", "press `animation` overriding the theme's: none, flash, pulse or fade")
+	flag.IntVar(&cfg.GuidedMax, "guided-max-attempts", 0, "in -guided mode, prompt a key again every 5s and fail it with a beep and banner after `n` prompts without a press")
+	flag.StringVar(&cfg.LoadCounts, "load-counts", "", "start the per-key counts from a -save-session `file` or a CSV of label,count rows, to accumulate usage across sessions")
+	flag.Parse()
+
+	os.Exit(run(cfg))
+}
+
+func run(cfg config) int {
+	if cfg.ExpectWindow < 0 {
+		log.Printf("-expect-window must not be negative")
+		return 2
+	}
+	var seq *sequence
+	if cfg.ExpectSequence != "" {
+		var err error
+		if seq, err = loadSequence(cfg.ExpectSequence, cfg.ExpectWindow); err != nil {
+			log.Printf("failed to load sequence: %v", err)
+			return 2
+		}
+	}
+
+	if _, ok := layoutRows[cfg.Layout]; !ok {
+		log.Printf("unknown layout %q, want one of %s", cfg.Layout, strings.Join(layoutNames(), ", "))
+		return 2
+	}
+	keys := initKeys(cfg.Layout, cfg.Clusters && !cfg.GridView)
+	if cfg.VIA != "" {
+		var err error
+		if keys, err = loadVIA(cfg.VIA); err != nil {
+			log.Printf("failed to load VIA definition: %v", err)
+			return 2
+		}
+	}
+	if cfg.LayoutFile != "" {
+		var err error
+		if keys, err = loadLayoutFile(cfg.LayoutFile); err != nil {
+			log.Printf("failed to load layout: %v", err)
+			return 2
+		}
+	}
+	physical := keys // before -grid-view squeezes it, for -export-kle
+	if cfg.GridView {
+		keys = gridKeys(keys)
+	}
+
+	switch cfg.Paste {
+	case "keys", "ignore", "mark":
+	default:
+		log.Printf("unknown -paste policy %q, want keys, ignore or mark", cfg.Paste)
+		return 2
+	}
+	sepChar, ok := separatorChars[cfg.SepStyle]
+	if !ok {
+		log.Printf("unknown -separator-style %q, want dash, line, double or thick", cfg.SepStyle)
+		return 2
+	}
+	if cfg.SepRows < 1 {
+		log.Printf("-separator-rows must be at least 1")
+		return 2
+	}
+	th, ok := themes[cfg.Theme]
+	if !ok {
+		log.Printf("unknown -theme %q, want one of %s", cfg.Theme, strings.Join(themeNames(), ", "))
+		return 2
+	}
+	if cfg.Animation != "" {
+		if _, ok := animations[cfg.Animation]; !ok {
+			log.Printf("unknown -animation %q, want none, flash, pulse or fade", cfg.Animation)
+			return 2
+		}
+		th.animation = cfg.Animation
+	}
+	switch cfg.OnComplete {
+	case "continue", "banner", "stop", "reset":
+	default:
+		log.Printf("unknown -on-complete action %q, want continue, banner, stop or reset", cfg.OnComplete)
+		return 2
+	}
+	if modes := btoi(cfg.Guided) + btoi(cfg.Reaction > 0) + btoi(cfg.Typing != ""); modes > 1 {
+		log.Printf("only one of -guided, -reaction and -typing can be used at a time")
+		return 2
+	}
+
+	var sinks []eventSink
+	defer func() {
+		for _, sk := range sinks {
+			if err := sk.close(); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+	if cfg.Piano {
+		pn, err := newPiano(keys, cfg.PianoMap, cfg.AudioCmd)
+		if err != nil {
+			log.Printf("failed to set up piano: %v", err)
+			return 2
+		}
+		sinks = append(sinks, pn)
+	}
+	if cfg.Rumble {
+		rb, err := openRumble()
+		if err != nil {
+			log.Printf("failed to set up rumble: %v", err)
+			return 2
+		}
+		if rb != nil {
+			sinks = append(sinks, rb)
+		}
+	}
+	if cfg.EvdevLog != "" {
+		el, err := newEvdevLog(cfg.EvdevLog, cfg.Layout)
+		if err != nil {
+			log.Printf("failed to create evdev log: %v", err)
+			return 2
+		}
+		sinks = append(sinks, el)
+	}
+	if cfg.SQLite != "" {
+		db, err := openSQLite(cfg.SQLite)
+		if err != nil {
+			log.Printf("failed to open SQLite database: %v", err)
+			return 2
+		}
+		sinks = append(sinks, db)
+	}
+
+	var base map[string]bool
+	if cfg.Baseline != "" {
+		ss, err := loadSession(cfg.Baseline)
+		if err != nil {
+			log.Printf("failed to load baseline: %v", err)
+			return 2
+		}
+		base = ss.pressedSet()
+	}
+
+	var seedCounts map[string]int
+	if cfg.LoadCounts != "" {
+		var err error
+		if seedCounts, err = loadCounts(cfg.LoadCounts); err != nil {
+			log.Printf("failed to load counts: %v", err)
+			return 2
+		}
+	}
+
 	s, err := tcell.NewScreen()
 	if err != nil {
-		log.Fatalf("failed to create screen: %v", err)
+		log.Printf("failed to create screen: %v", err)
+		return 2
 	}
 	if err := s.Init(); err != nil {
-		log.Fatalf("failed to init screen: %v", err)
+		log.Printf("failed to init screen: %v", err)
+		return 2
+	}
+
+	// newView builds the runtime state from the flags, at startup and again
+	// for every -reset-key press
+	newView := func() *view {
+		v := &view{
+			keys:       keys,
+			pressed:    map[string]bool{},
+			counts:     map[string]int{},
+			lastPress:  map[string]time.Time{},
+			hover:      -1,
+			combos:     map[tcell.ModMask]bool{},
+			mismatched: map[string]bool{},
+			base:       base,
+			dual:       cfg.DualPane,
+			grid:       cfg.GridView,
+			pan:        cfg.Pan,
+			sep:        separator{char: sepChar, rows: cfg.SepRows, caption: cfg.SepCaption},
+			theme:      th,
+		}
+		v.start, v.lastKey, v.idleThreshold = time.Now(), time.Now(), cfg.IdleThreshold
+		if cfg.CaseSensitive {
+			v.cases = map[string]int{}
+		}
+		v.seed = seedCounts
+		maps.Copy(v.counts, v.seed)
+		v.keycaps = cfg.Keycaps && s.CanDisplay(keycapMark, false)
+		if cfg.AutoFit {
+			v.layout = keys
+			w, _ := s.Size()
+			v.fit(w)
+		}
+		return v
+	}
+	v := newView()
+
+	// Every way out of the loop, a panic included, goes through teardown so
+	// the terminal gets its modes (paste, mouse, keyboard protocol) back.
+	teardown := teardownOnce(s)
+	defer teardown()
+	stopSignals := watchSignals(s)
+	defer stopSignals()
+
+	// the first draw waits once for the terminal to settle on its size, not
+	// again on every -reset-key restart
+	if cfg.StartupSync > 0 {
+		for _, ev := range waitForSize(s, cfg.StartupSync) {
+			s.PostEvent(ev)
+		}
+		if v.layout != nil {
+			w, _ := s.Size()
+			v.fit(w)
+		}
+	}
+
+	code, note := loop(s, cfg, v, seq, sinks)
+	for code == exitRestart {
+		seen := v.forbiddenSeen
+		v = newView()
+		v.forbiddenSeen = seen
+		if seq != nil {
+			seq.reset()
+		}
+		code, note = loop(s, cfg, v, seq, sinks)
+	}
+	teardown()
+
+	if note != "" {
+		fmt.Println(note)
+	}
+	if r := v.report(); r != "" {
+		fmt.Println(r)
+	}
+	if v.forbiddenSeen {
+		code = 1
+	}
+	if cfg.SaveSession != "" {
+		if err := saveSession(cfg.SaveSession, v); err != nil {
+			log.Printf("failed to save session: %v", err)
+		}
 	}
-	defer s.Fini()
+	if cfg.Rotate > 0 {
+		// the exit cuts the last window short; keep it like the others
+		if path, err := writeReport(cfg.RotateDir, v, time.Now()); err != nil {
+			log.Printf("failed to write session report: %v", err)
+		} else {
+			fmt.Println("session report written to", path)
+		}
+	}
+	if cfg.ExportKLE != "" {
+		if err := exportKLE(cfg.ExportKLE, physical, v.pressed); err != nil {
+			log.Printf("failed to export KLE layout: %v", err)
+		}
+	}
+	if seq != nil {
+		fmt.Println("expect-sequence:", seq.result())
+		if seq.failure != "" || !seq.complete() {
+			return 1
+		}
+	}
+	return code
+}
+
+// exitRestart is the code loop returns when -reset-key asks to start over
+// from the startup configuration
+const exitRestart = -1
 
-	keys := initKeys()
-	logs := []string{}
-	pressed := map[string]bool{}
+// loop runs the event loop until an exit condition is met and returns the
+// process exit code along with an optional message for the terminal.
+func loop(s tcell.Screen, cfg config, v *view, seq *sequence, sinks []eventSink) (int, string) {
+	keys := v.keys
+	var forbid []seqStep
+	for _, spec := range strings.Split(cfg.Forbid, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			forbid = append(forbid, parseStep(spec))
+		}
+	}
+	var exitSeq, recent []seqStep // -exit-sequence and the keys just pressed
+	for _, spec := range strings.Fields(cfg.ExitSequence) {
+		exitSeq = append(exitSeq, parseStep(spec))
+	}
+	animating := false // an animTick is on its way
+	var resetKey *seqStep
+	if cfg.ResetKey != "" {
+		st := parseStep(cfg.ResetKey)
+		resetKey = &st
+	}
 	escCount, enterCount, spaceCount := 0, 0, 0
+	var exitHold keyHold
+	var pendingMod *tcell.EventKey // modifier-only event held back by -coalesce-mods
+	gotKey := false
+	complete := false           // every layout key pressed, -on-complete done
+	pasting, pasted := false, 0 // inside a bracketed paste, and its length so far
+	if cfg.Paste != "keys" {
+		s.EnablePaste()
+	}
+	if cfg.Hover {
+		s.EnableMouse(tcell.MouseMotionEvents)
+	}
 
-	// initial draw
-	drawAll(s, keys, logs, pressed)
-	s.Show()
+	redraw := func() {
+		drawAll(s, v)
+		s.Show()
+	}
+	// resolve names the layout key an event stands for, with the modifiers
+	// it counts as, and reports whether AltGr typed it
+	resolve := func(ev *tcell.EventKey) (string, tcell.ModMask, bool) {
+		label, mods, altGr := labelFromEvent(ev), eventMods(ev), false
+		if l, ok := altGrKey(cfg.Layout, ev); ok {
+			label, altGr = l, true
+			mods &^= tcell.ModCtrl | tcell.ModAlt
+		}
+		if cfg.MetaAsAlt {
+			if mods&tcell.ModMeta != 0 {
+				mods |= tcell.ModAlt
+			}
+			if l, ok := optionKey(cfg.Layout, ev); ok && !altGr {
+				label = l
+				mods |= tcell.ModAlt
+			}
+		}
+		return label, mods, altGr
+	}
+	formatLog := func(ev *tcell.EventKey) string {
+		code := int(ev.Key())
+		mods := modString(ev.Modifiers())
+		label := labelFromEvent(ev)
+		line := fmt.Sprintf("%-7s | Code=%3d | Mods=%s", label, code, mods)
+		if !cfg.NoTimestamps {
+			line = ev.When().Format("15:04:05") + " | " + line
+		}
+		if cfg.LogMatrix {
+			matrix := "-"
+			if k, ok := findKey(keys, label); ok && k.HasMatrix {
+				matrix = fmt.Sprintf("%d,%d", k.Row, k.Col)
+			}
+			line += " | Matrix=" + matrix
+		}
+		if cfg.LogResolved {
+			resolved := "<none>"
+			l, _, _ := resolve(ev)
+			if _, ok := findKey(keys, l); ok {
+				resolved = l
+			}
+			line += " | Key=" + resolved
+		}
+		return line
+	}
+	// lastEvent is when each key was last logged, to tell autorepeat from
+	// real presses
+	lastEvent := map[string]time.Time{}
+	appendLog := func(ev *tcell.EventKey) {
+		line := formatLog(ev)
+		label := labelFromEvent(ev)
+		if last, ok := lastEvent[label]; ok && cfg.RepeatThreshold > 0 && ev.When().Sub(last) <= cfg.RepeatThreshold {
+			line += " (repeat)"
+		}
+		lastEvent[label] = ev.When()
+		v.addLog(s, line)
+	}
+
+	// every posts data every d until the loop returns; after posts it once
+	// after d unless the loop has returned by then, so no timer reaches the
+	// loop a -reset-key press starts next
+	done := make(chan struct{})
+	defer close(done)
+	every := func(d time.Duration, data any) {
+		t := time.NewTicker(d)
+		go func() {
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					s.PostEvent(tcell.NewEventInterrupt(data))
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	after := func(d time.Duration, data any) *time.Timer {
+		return time.AfterFunc(d, func() {
+			select {
+			case <-done:
+			default:
+				s.PostEvent(tcell.NewEventInterrupt(data))
+			}
+		})
+	}
+
+	if cfg.Watchdog > 0 {
+		defer after(cfg.Watchdog, watchdogTimeout{}).Stop()
+	}
+
+	var rt *reactionTest
+	if cfg.Reaction > 0 {
+		rt = newReactionTest(cfg.Reaction, keys)
+		if rt == nil {
+			return 2, "reaction test: layout has no keys that can be prompted"
+		}
+		after(rt.delay(), reactionPrompt{})
+		v.prompt = rt.status()
+	}
+
+	var gd *guided
+	if cfg.Guided {
+		layout := cfg.Layout
+		if cfg.VIA != "" || cfg.LayoutFile != "" {
+			layout = ""
+		}
+		if gd = newGuided(keys, layout); gd == nil {
+			return 2, "guided: layout has no keys that can be prompted"
+		}
+		gd.hold, gd.maxAttempts = cfg.GuidedHold, cfg.GuidedMax
+		if cfg.GuidedRandom {
+			seed := cfg.GuidedSeed
+			if seed == 0 {
+				seed = time.Now().UnixNano()
+			}
+			gd.shuffle(seed)
+		}
+		v.target, v.prompt = gd.expected(), gd.status()
+	}
+
+	var tt *typingTest
+	if cfg.Typing != "" {
+		var err error
+		if tt, err = loadTypingTest(cfg.Typing); err != nil {
+			return 2, "typing: " + err.Error()
+		}
+		tt.wpm, tt.accuracy = cfg.TypingWPM, cfg.TypingAccuracy
+		v.target, v.prompt = tt.expected(), tt.status()
+	}
+
+	// summary reports the active test modes when the loop ends
+	summary := func() string {
+		var notes []string
+		if rt != nil {
+			notes = append(notes, rt.result())
+		}
+		if gd != nil {
+			notes = append(notes, gd.result())
+		}
+		if tt != nil {
+			notes = append(notes, tt.result())
+		}
+		return strings.Join(notes, "\n")
+	}
+	// finish ends the loop normally, failing a typing test that missed its
+	// thresholds
+	finish := func() (int, string) {
+		if tt != nil && !tt.passed() || gd != nil && len(gd.failed) > 0 {
+			return 1, summary()
+		}
+		return 0, summary()
+	}
+
+	if cfg.IdleThreshold > 0 {
+		every(time.Second, clockTick{})
+	}
+
+	var hintSince time.Time
+	if cfg.Hint > 0 {
+		every(hintPulse, hintTick{})
+	}
+	if cfg.Rotate > 0 {
+		every(cfg.Rotate, sessionRotate{})
+	}
+
+	// guidedStep shows the outcome of a guided press or re-prompt and, with
+	// -guided-max-attempts, arms the re-prompt of a newly prompted key. It
+	// reports whether every key has been prompted.
+	promptedPos := -1
+	guidedStep := func(line string, now time.Time) bool {
+		if line != "" {
+			v.addLog(s, line)
+		}
+		v.transposed = gd.swapped
+		if alarm := gd.takeAlarm(); alarm != "" {
+			v.failed = gd.failed
+			v.banner = alarm
+			s.Beep()
+		}
+		if gd.done() {
+			return true
+		}
+		v.target, v.prompt = gd.expected(), gd.status()
+		v.progress = gd.progress(now)
+		if w := gd.warning(); w != "" {
+			v.banner = w
+		}
+		if gd.maxAttempts > 0 && gd.pos != promptedPos {
+			promptedPos = gd.pos
+			after(repromptDelay, guidedTimeout{gd.pos})
+		}
+		return false
+	}
+	if gd != nil {
+		guidedStep("", time.Now())
+	}
+
+	// waitingFor is the key spec a test mode is currently waiting on
+	waitingFor := func() string {
+		switch {
+		case gd != nil:
+			return gd.expected()
+		case seq != nil:
+			return seq.pending()
+		}
+		return ""
+	}
+	// watchSwallowed arms a hint for keys the terminal may never pass on,
+	// and drops a shown hint once the test has moved past its key
+	hinted := ""
+	watchSwallowed := func() {
+		spec := waitingFor()
+		if spec == hinted {
+			return
+		}
+		if h, ok := swallowedKeys[hinted]; ok && v.banner == "HINT: "+h {
+			v.banner = ""
+		}
+		hinted = spec
+		if _, ok := swallowedKeys[spec]; ok {
+			after(swallowDelay, swallowedTimeout{spec})
+		}
+	}
+	watchSwallowed()
+
+	// updateStatus rewrites -status-file if the coverage has changed
+	statusTested := -1
+	updateStatus := func() {
+		tested, total := coverage(keys, v.pressed)
+		if cfg.StatusFile == "" || tested == statusTested {
+			return
+		}
+		statusTested = tested
+		if err := writeStatus(cfg.StatusFile, tested, total); err != nil {
+			v.addLog(s, "status file: "+err.Error())
+		}
+	}
+	updateStatus()
+
+	redraw()
 
 	for {
 		ev := s.PollEvent()
 		switch ev := ev.(type) {
 		case *tcell.EventKey:
+			// --- pasted text never counts as key presses ---
+			if pasting {
+				pasted++
+				if cfg.Paste == "mark" {
+					v.addLog(s, "PASTE | "+formatLog(ev))
+					redraw()
+				}
+				continue
+			}
+
+			// --- start over from the startup configuration ---
+			if resetKey != nil {
+				label, mods, _ := resolve(ev)
+				if label == resetKey.Label && mods&resetKey.Mods == resetKey.Mods {
+					return exitRestart, ""
+				}
+			}
+
+			// --- Shift+arrows pan an oversized keyboard ---
+			if cfg.Pan && ev.Modifiers()&tcell.ModShift != 0 {
+				if dx, dy, ok := panStep(ev.Key()); ok {
+					v.ox += dx
+					v.oy += dy
+					redraw()
+					continue
+				}
+			}
+
+			mainLabel, evMods, altGr := resolve(ev)
+			if !gotKey && strings.HasPrefix(v.banner, "WATCHDOG") {
+				v.banner = ""
+			}
+			gotKey = true
+			v.events++
+			v.markActive(ev.When())
+
+			// --- coalesce a held-back modifier with this key ---
+			if pendingMod != nil {
+				m := pendingMod.Modifiers()
+				if ev.When().Sub(pendingMod.When()) > cfg.CoalesceMods || evMods&m != m {
+					appendLog(pendingMod)
+				}
+				pendingMod = nil
+			}
+
+			// --- expected sequence ---
+			if seq != nil {
+				if !seq.feed(mainLabel, evMods, ev.When()) || seq.complete() {
+					return finish()
+				}
+			}
+
 			// --- exit logic ---
+			// with -exit-hold, a press only counts once held long enough
+			exitHeld := exitHold.press(mainLabel, ev.When(), cfg.ExitHold)
+			if len(exitSeq) > 0 {
+				// the sequence replaces the repeated-press exits
+				exitHeld = false
+				recent = append(recent, seqStep{Label: mainLabel, Mods: evMods})
+				if len(recent) > len(exitSeq) {
+					recent = recent[1:]
+				}
+				if slices.EqualFunc(recent, exitSeq, func(got, want seqStep) bool {
+					return got.Label == want.Label && got.Mods&want.Mods == want.Mods
+				}) {
+					return finish()
+				}
+			}
 			switch ev.Key() {
 			case tcell.KeyEscape:
-				escCount++
+				if exitHeld {
+					escCount++
+				}
 				if escCount >= 5 {
-					return
+					return finish()
 				}
 			case tcell.KeyEnter:
-				enterCount++
+				if exitHeld {
+					enterCount++
+				}
 				if enterCount >= 5 {
-					return
+					return finish()
 				}
 			case tcell.KeyRune:
-				if ev.Rune() == ' ' {
-					spaceCount++
+				// a typing test is full of spaces
+				if ev.Rune() == ' ' && tt == nil {
+					if exitHeld {
+						spaceCount++
+					}
 					if spaceCount >= 5 {
-						return
+						return finish()
 					}
 				}
 			}
 
+			for _, sk := range sinks {
+				sk.keyEvent(ev, mainLabel)
+			}
+
 			// --- mark pressed keys permanently ---
-			mainLabel := labelFromEvent(ev)
-			pressed[mainLabel] = true
-			if ev.Modifiers()&tcell.ModCtrl != 0 || (ev.Key() >= tcell.KeyCtrlA && ev.Key() <= tcell.KeyCtrlZ) {
-				pressed["Ctrl"] = true
+			v.pressed[mainLabel] = true
+			v.counts[mainLabel]++
+			v.lastPress[mainLabel] = ev.When()
+			v.combos[evMods&(tcell.ModCtrl|tcell.ModAlt|tcell.ModShift)] = true
+			if v.cases != nil && ev.Key() == tcell.KeyRune {
+				v.cases[mainLabel] |= caseBit(ev.Rune())
 			}
-			if ev.Modifiers()&tcell.ModAlt != 0 {
-				pressed["Alt"] = true
+			for _, f := range forbid {
+				if f.Label == mainLabel && evMods&f.Mods == f.Mods {
+					spec := keySpec(seqStep{Label: mainLabel, Mods: evMods})
+					if !slices.Contains(v.forbidden, spec) {
+						v.forbidden = append(v.forbidden, spec)
+					}
+					v.forbiddenSeen = true
+					v.banner = "FORBIDDEN KEY: " + spec + " must not exist on this board"
+					v.addLog(s, "forbidden key pressed: "+spec)
+				}
 			}
-			if ev.Modifiers()&tcell.ModShift != 0 {
-				pressed["Shift"] = true
+			if k, ok := findKey(keys, mainLabel); ok && k.HasCode && sentCode(ev) != k.Code {
+				v.mismatched[mainLabel] = true
+				v.addLog(s, fmt.Sprintf("code mismatch: %s sent %d, expected %d", mainLabel, sentCode(ev), k.Code))
+			}
+			if altGr {
+				v.pressed["AltGr"] = true
+			}
+			if evMods&tcell.ModCtrl != 0 {
+				v.pressed["Ctrl"] = true
+			}
+			if evMods&tcell.ModAlt != 0 {
+				v.pressed["Alt"] = true
+			}
+			if evMods&tcell.ModShift != 0 {
+				v.pressed["Shift"] = true
 			}
 			// CapsLock heuristic
-			if ev.Key() == tcell.KeyRune {
+			if ev.Key() == tcell.KeyRune && !cfg.NoCapsLock {
 				r := ev.Rune()
 				if unicode.IsLetter(r) && unicode.IsUpper(r) && ev.Modifiers()&tcell.ModShift == 0 {
-					pressed["CapsLock"] = true
+					v.pressed["CapsLock"] = true
+				}
+			}
+
+			updateStatus()
+
+			// --- full coverage ---
+			if tested, total := coverage(keys, v.pressed); tested == total && !complete {
+				complete = true
+				switch cfg.OnComplete {
+				case "banner":
+					v.banner = "COMPLETE: every key has been tested"
+				case "stop":
+					code, note := finish()
+					line := fmt.Sprintf("complete: all %d keys tested", total)
+					if note != "" {
+						line = note + "\n" + line
+					}
+					return code, line
+				case "reset":
+					v.reset(ev.When())
+					v.addLog(s, fmt.Sprintf("all %d keys tested, starting over", total))
+					complete = false
+					updateStatus()
 				}
 			}
 
 			// --- append to log ---
-			ts := time.Now().Format("15:04:05")
-			code := int(ev.Key())
-			mods := modString(ev.Modifiers())
-			logs = append(logs, fmt.Sprintf("%s | %-7s | Code=%3d | Mods=%s", ts, mainLabel, code, mods))
+			if cfg.CoalesceMods > 0 && isModifierOnly(ev) {
+				pendingMod = ev
+				after(cfg.CoalesceMods, coalesceTimeout{})
+			} else {
+				appendLog(ev)
+			}
 
-			// --- safe trim ---
-			_, scrH := s.Size()
-			sepY := keys[len(keys)-1].Y + keys[len(keys)-1].H
-			maxLines := scrH - sepY - 1
+			// --- reaction test ---
+			if rt != nil {
+				line, hit := rt.press(mainLabel, ev.When())
+				v.addLog(s, line)
+				if rt.done() {
+					return finish()
+				}
+				if hit {
+					after(rt.delay(), reactionPrompt{})
+				}
+				v.target, v.prompt = rt.target, rt.status()
+			}
 
-			if maxLines <= 0 {
-				// no room at all
-				logs = []string{}
-			} else if len(logs) > maxLines {
-				// only keep the bottom-most maxLines entries
-				logs = logs[len(logs)-maxLines:]
+			// --- guided mode ---
+			if gd != nil && guidedStep(gd.press(mainLabel, ev.When()), ev.When()) {
+				return finish()
 			}
 
+			// --- typing test ---
+			if tt != nil {
+				if line := tt.press(ev); line != "" {
+					v.addLog(s, line)
+				}
+				if tt.done() {
+					return finish()
+				}
+				v.target, v.prompt = tt.expected(), tt.status()
+			}
+
+			// --- press animation runs until it has played out ---
+			if v.theme.animating(ev.When(), time.Now()) && !animating {
+				animating = true
+				after(animFrame, animTick{})
+			}
+
+			watchSwallowed()
+
 			// --- redraw & show ---
-			drawAll(s, keys, logs, pressed)
-			s.Show()
+			redraw()
+
+		case *tcell.EventInterrupt:
+			switch d := ev.Data().(type) {
+			case watchdogTimeout:
+				if !gotKey {
+					msg := fmt.Sprintf("WATCHDOG: no key pressed within %v, check the keyboard and connection", cfg.Watchdog)
+					if cfg.WatchdogFail {
+						return 1, msg
+					}
+					v.banner = msg
+					redraw()
+				}
+			case coalesceTimeout:
+				// nothing followed the modifier, so log it on its own
+				if pendingMod != nil && time.Since(pendingMod.When()) >= cfg.CoalesceMods {
+					appendLog(pendingMod)
+					pendingMod = nil
+					redraw()
+				}
+			case swallowedTimeout:
+				if d.spec == waitingFor() && v.banner == "" {
+					v.banner = "HINT: " + swallowedKeys[d.spec]
+					redraw()
+				}
+			case signalReceived:
+				note := fmt.Sprintf("stopped by signal: %v", d.sig)
+				if sum := summary(); sum != "" {
+					note = sum + "\n" + note
+				}
+				return signalExitCode(d.sig), note
+			case clockTick:
+				redraw()
+			case animTick:
+				animating = v.theme.animating(v.lastKey, time.Now())
+				if animating {
+					after(animFrame, animTick{})
+				}
+				redraw()
+			case hintTick:
+				now := time.Now()
+				if v.hint == "" || v.pressed[v.hint] || now.Sub(hintSince) >= cfg.Hint {
+					v.hint, hintSince = nextHint(keys, v.pressed, v.hint), now
+					v.hintOn = true
+				} else {
+					v.hintOn = !v.hintOn
+				}
+				redraw()
+			case sessionRotate:
+				now := time.Now()
+				if path, err := writeReport(cfg.RotateDir, v, now); err != nil {
+					v.addLog(s, "session report: "+err.Error())
+				} else {
+					v.addLog(s, "session report written to "+path)
+				}
+				v.reset(now)
+				complete = false
+				updateStatus()
+				redraw()
+			case guidedTimeout:
+				if d.pos == gd.pos {
+					promptedPos = -1 // prompt the same key again
+					if guidedStep(gd.reprompt(time.Now()), time.Now()) {
+						return finish()
+					}
+					redraw()
+				}
+			case reactionPrompt:
+				rt.prompt(time.Now())
+				v.target, v.prompt = rt.target, rt.status()
+				redraw()
+			}
+
+		case *tcell.EventMouse:
+			x, y := ev.Position()
+			hover := -1
+			if p := v.panes(s.Size()); x < p.viewW && y < p.viewH {
+				hover = keyAt(v.keys, x+v.ox, y+v.oy)
+			}
+			if hover != v.hover {
+				v.hover = hover
+				redraw()
+			}
+
+		case *tcell.EventPaste:
+			pasting = ev.Start()
+			if ev.End() && cfg.Paste == "ignore" {
+				v.addLog(s, fmt.Sprintf("paste of %d characters ignored", pasted))
+				redraw()
+			}
+			if ev.Start() {
+				pasted = 0
+			}
 
 		case *tcell.EventResize:
+			if v.layout != nil {
+				w, _ := ev.Size()
+				v.fit(w)
+				redraw()
+			}
 			s.Sync()
 		}
 	}
 }
 
-func initKeys() []Key {
+// eventSink is handed every key press that counts towards the test
+type eventSink interface {
+	keyEvent(ev *tcell.EventKey, label string)
+	close() error
+}
+
+// view is the state drawn by drawAll
+type view struct {
+	keys     []Key
+	logs     []string
+	pressed  map[string]bool
+	banner   string  // warning centered on the separator
+	prompt   string  // instruction shown at the start of the separator
+	target   string  // key highlighted as the one to press next
+	progress float64 // how far a hold on target has got, 0 to 1
+	events   int
+	dual     bool // stats and log go right of the keyboard
+	grid     bool // keys are single cells, see gridKeys
+	pan      bool // keyboard may be larger than the screen and scrolled
+	ox, oy   int  // pan offset
+	sep      separator
+	theme    theme
+	keycaps  bool // draw eligible labels as emoji keycaps
+	counts   map[string]int
+	// lastPress is when each key was last pressed, for the hover tooltip
+	lastPress map[string]time.Time
+	hint      string                 // untested key -hint points at
+	hintOn    bool                   // hint is in the lit half of its blink
+	hover     int                    // index of the key under the mouse, -1 for none
+	combos    map[tcell.ModMask]bool // Ctrl/Alt/Shift combinations seen
+	cases     map[string]int         // caseLower/caseUpper per letter, nil unless -case-sensitive
+	base      map[string]bool        // keys pressed in the -baseline session
+	// mismatched keys sent a code other than the layout expects
+	mismatched map[string]bool
+	seed       map[string]int // -load-counts counts, which reset starts from again
+	forbidden  []string       // -forbid keys pressed this session, as key specs
+	// forbiddenSeen survives reset and -reset-key so an earlier forbidden key
+	// still fails the run
+	forbiddenSeen bool
+	failed        []string // keys guided mode gave up on
+	transposed    []string // keys guided mode saw pressed in swapped order
+	// layout is the unscaled keyboard that -auto-fit shrinks into keys
+	layout   []Key
+	tooSmall bool // even shrunk, the keyboard does not fit
+
+	// session timing for the elapsed/idle stats
+	start, lastKey time.Time
+	idle           time.Duration
+	idleThreshold  time.Duration
+}
+
+// markActive records a key press at t, adding the gap before it to the idle
+// time if it was longer than the threshold
+func (v *view) markActive(t time.Time) {
+	if gap := t.Sub(v.lastKey); v.idleThreshold > 0 && gap > v.idleThreshold {
+		v.idle += gap
+	}
+	v.lastKey = t
+}
+
+// idleAt returns the idle time so far, including the current gap
+func (v *view) idleAt(now time.Time) time.Duration {
+	idle := v.idle
+	if gap := now.Sub(v.lastKey); v.idleThreshold > 0 && gap > v.idleThreshold {
+		idle += gap
+	}
+	return idle
+}
+
+// addLog appends a log line and trims the log to the rows left for it
+func (v *view) addLog(s tcell.Screen, line string) {
+	v.logs = append(v.logs, line)
+
+	// --- safe trim ---
+	maxLines := v.panes(s.Size()).logH
+
+	if maxLines <= 0 {
+		// no room at all
+		v.logs = []string{}
+	} else if len(v.logs) > maxLines {
+		// only keep the bottom-most maxLines entries
+		v.logs = v.logs[len(v.logs)-maxLines:]
+	}
+}
+
+// layoutRows holds the four alphanumeric rows of each built-in layout, as
+// reported by the terminal for an unshifted press. The other rows are the
+// same everywhere, bar right Alt being AltGr on layouts that have one, and
+// every layout has the same number of keys per row so a key's index names
+// the same physical position in all of them.
+var layoutRows = map[string][4][]string{
+	"us": {
+		{"`", "1", "2", "3", "4", "5", "6", "7", "8", "9", "0", "-", "=", "Backspace"},
+		{"Tab", "Q", "W", "E", "R", "T", "Y", "U", "I", "O", "P", "[", "]", "\\"},
+		{"CapsLock", "A", "S", "D", "F", "G", "H", "J", "K", "L", ";", "'", "Enter"},
+		{"Shift", "Z", "X", "C", "V", "B", "N", "M", ",", ".", "/", "Shift"},
+	},
+	"de": {
+		{"^", "1", "2", "3", "4", "5", "6", "7", "8", "9", "0", "ß", "´", "Backspace"},
+		{"Tab", "Q", "W", "E", "R", "T", "Z", "U", "I", "O", "P", "Ü", "+", "#"},
+		{"CapsLock", "A", "S", "D", "F", "G", "H", "J", "K", "L", "Ö", "Ä", "Enter"},
+		{"Shift", "Y", "X", "C", "V", "B", "N", "M", ",", ".", "-", "Shift"},
+	},
+	"fr": {
+		{"²", "&", "É", "\"", "'", "(", "-", "È", "_", "Ç", "À", ")", "=", "Backspace"},
+		{"Tab", "A", "Z", "E", "R", "T", "Y", "U", "I", "O", "P", "^", "$", "*"},
+		{"CapsLock", "Q", "S", "D", "F", "G", "H", "J", "K", "L", "M", "Ù", "Enter"},
+		{"Shift", "W", "X", "C", "V", "B", "N", ",", ";", ":", "!", "Shift"},
+	},
+}
+
+// layoutNames lists the built-in layouts for flag help and suggestions
+func layoutNames() []string {
+	return []string{"us", "de", "fr"}
+}
+
+// initKeys builds a built-in layout. With boxed set every key is tagged
+// with its cluster and the rows are shifted to leave room for the borders.
+func initKeys(layout string, boxed bool) []Key {
 	var out []Key
-	addRow := func(labels []string, y int) {
-		x := 0
+	clusterNames := []string{"Function", "Main", "Navigation", "Arrows"}
+	addRow := func(labels []string, y, cluster int) {
+		x, name := 0, ""
+		if boxed {
+			x, y, name = 1, y+cluster+1, clusterNames[cluster]
+		}
 		for _, L := range labels {
-			w := len(L) + 2
-			out = append(out, Key{Label: L, X: x, Y: y, W: w, H: 3})
+			w := utf8.RuneCountInString(L) + 2
+			out = append(out, Key{Label: L, X: x, Y: y, W: w, H: 3, Cluster: name})
 			x += w + 1
 		}
 	}
-	addRow([]string{"Esc", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12"}, 0)
-	addRow([]string{"`", "1", "2", "3", "4", "5", "6", "7", "8", "9", "0", "-", "=", "Backspace"}, 4)
-	addRow([]string{"Tab", "Q", "W", "E", "R", "T", "Y", "U", "I", "O", "P", "[", "]", "\\"}, 8)
-	addRow([]string{"CapsLock", "A", "S", "D", "F", "G", "H", "J", "K", "L", ";", "'", "Enter"}, 12)
-	addRow([]string{"Shift", "Z", "X", "C", "V", "B", "N", "M", ",", ".", "/", "Shift"}, 16)
-	addRow([]string{"Fn", "Ctrl", "Win", "Alt", "Space", "Alt", "Win", "Menu", "Ctrl"}, 20)
-	addRow([]string{"Insert", "Home", "PgUp"}, 24)
-	addRow([]string{"Delete", "End", "PgDn"}, 28)
-	addRow([]string{"Left", "Down", "Right", "Up"}, 32)
+	rows := layoutRows[layout]
+	rightAlt := "Alt"
+	if len(altGrChars[layout]) > 0 {
+		rightAlt = "AltGr"
+	}
+	addRow([]string{"Esc", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12"}, 0, 0)
+	addRow(rows[0], 4, 1)
+	addRow(rows[1], 8, 1)
+	addRow(rows[2], 12, 1)
+	addRow(rows[3], 16, 1)
+	addRow([]string{"Fn", "Ctrl", "Win", "Alt", "Space", rightAlt, "Win", "Menu", "Ctrl"}, 20, 1)
+	addRow([]string{"Insert", "Home", "PgUp"}, 24, 2)
+	addRow([]string{"Delete", "End", "PgDn"}, 28, 2)
+	addRow([]string{"Left", "Down", "Right", "Up"}, 32, 3)
 	return out
 }
 
-func drawAll(s tcell.Screen, keys []Key, logs []string, pressed map[string]bool) {
-	s.Clear()
-	blue := tcell.StyleDefault.Background(tcell.ColorBlue)
+// gridKeys scales a layout down to one cell per key unit. Keys are at
+// least one unit apart, so no two keys share a cell.
+func gridKeys(keys []Key) []Key {
+	out := make([]Key, len(keys))
+	for i, k := range keys {
+		k.X, k.Y, k.W, k.H = k.X/cellsPerUnitX, k.Y/cellsPerUnitY, 1, 1
+		k.Cluster = ""
+		out[i] = k
+	}
+	return out
+}
 
-	// draw keyboard
+// findKey returns the first key in the layout with the given label
+func findKey(keys []Key, label string) (Key, bool) {
 	for _, k := range keys {
-		if pressed[k.Label] {
-			drawKey(s, k, blue)
-		} else {
-			drawKey(s, k, tcell.StyleDefault)
+		if k.Label == label {
+			return k, true
 		}
 	}
+	return Key{}, false
+}
 
-	// separator line
-	w, _ := s.Size()
-	sepY := keys[len(keys)-1].Y + keys[len(keys)-1].H
-	for x := 0; x < w; x++ {
-		s.SetContent(x, sepY, '-', nil, tcell.StyleDefault)
+// keyboardBottom returns the first row below the keyboard, including the
+// bottom border of any cluster box.
+func keyboardBottom(keys []Key) int {
+	bottom := 0
+	for _, k := range keys {
+		b := k.Y + k.H
+		if k.Cluster != "" {
+			b++
+		}
+		if b > bottom {
+			bottom = b
+		}
 	}
+	return bottom
+}
 
-	// draw log lines
-	for i, line := range logs {
-		for j, r := range line {
-			if j >= w {
-				break
+func drawAll(s tcell.Screen, v *view) {
+	s.Clear()
+	keys, logs, pressed, banner := v.keys, v.logs, v.pressed, v.banner
+	w, h := s.Size()
+	if v.tooSmall {
+		drawText(s, 0, 0, w, "Terminal too small for the keyboard, please widen it", tcell.StyleDefault)
+		return
+	}
+	p := v.panes(w, h)
+	v.clampPan(p)
+	kb := viewport{Screen: s, ox: v.ox, oy: v.oy, w: p.viewW, h: p.viewH}
+	now := time.Now()
+	yellow := tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
+	green := tcell.StyleDefault.Background(tcell.ColorGreen).Foreground(tcell.ColorBlack)
+	red := tcell.StyleDefault.Background(tcell.ColorRed).Foreground(tcell.ColorWhite)
+	cyan := tcell.StyleDefault.Background(tcell.ColorDarkCyan).Foreground(tcell.ColorWhite)
+	magenta := tcell.StyleDefault.Background(tcell.ColorDarkMagenta).Foreground(tcell.ColorWhite)
+	purple := tcell.StyleDefault.Background(tcell.ColorPurple).Foreground(tcell.ColorWhite)
+
+	// keys sharing a switch matrix row or column with the hovered key can
+	// ghost against it
+	var hovered *Key
+	if v.hover >= 0 && v.hover < len(keys) && keys[v.hover].HasMatrix {
+		hovered = &keys[v.hover]
+	}
+	orange := tcell.StyleDefault.Background(tcell.ColorDarkOrange).Foreground(tcell.ColorBlack)
+
+	// draw keyboard, marking changes from the baseline if there is one
+	for i, k := range keys {
+		style := tcell.StyleDefault
+		switch {
+		case v.target != "" && k.Label == v.target:
+			style = yellow
+		case hovered != nil && i != v.hover && k.HasMatrix && (k.Row == hovered.Row || k.Col == hovered.Col):
+			style = orange
+		case v.hintOn && k.Label == v.hint:
+			style = cyan
+		case slices.Contains(v.failed, k.Label):
+			style = red
+		case slices.Contains(v.transposed, k.Label):
+			style = purple
+		case v.mismatched[k.Label]:
+			style = magenta
+		case v.base != nil && pressed[k.Label] && !v.base[k.Label]:
+			style = green
+		case v.base != nil && !pressed[k.Label] && v.base[k.Label]:
+			style = red
+		case pressed[k.Label]:
+			style = v.theme.pressStyle(v.lastPress[k.Label], now)
+		}
+		if v.grid {
+			// one unlabelled cell per key, grey until something happens
+			if style == tcell.StyleDefault {
+				style = style.Background(tcell.ColorGray)
 			}
-			s.SetContent(j, sepY+1+i, r, nil, tcell.StyleDefault)
+			kb.SetContent(k.X, k.Y, ' ', nil, style)
+			continue
 		}
+		drawKey(kb, k, style, v.keycaps)
+		if k.Label == v.target && v.progress > 0 {
+			// hold progress fills the bottom row of the key
+			for dx := 0; dx < int(v.progress*float64(k.W)); dx++ {
+				kb.SetContent(k.X+dx, k.Y+k.H-1, ' ', nil, green)
+			}
+		}
+	}
+
+	// cluster boxes
+	for _, c := range clusters(keys) {
+		drawCluster(kb, c)
+	}
+
+	// pane divider
+	if p.split {
+		for y := 0; y < h; y++ {
+			s.SetContent(p.divX, y, tcell.RuneVLine, nil, tcell.StyleDefault)
+		}
+	}
+
+	// stats panel
+	stats := v.stats()
+	if !p.split {
+		stats = []string{statsLine(stats)}
+	}
+	for i, line := range stats {
+		drawText(s, p.statsX, p.statsY+i, p.statsW, line, tcell.StyleDefault)
+	}
+
+	// separator, with its caption centered on the middle row
+	sepChar := v.sep.char
+	if sepChar == 0 {
+		sepChar = '-'
+	}
+	for y := p.sepY; y < p.sepY+p.sepH; y++ {
+		for x := p.sepX; x < p.sepX+p.sepW; x++ {
+			s.SetContent(x, y, sepChar, nil, tcell.StyleDefault)
+		}
+	}
+	if v.sep.caption != "" {
+		text := " " + v.sep.caption + " "
+		start := max((p.sepW-runewidth.StringWidth(text))/2, 0)
+		drawText(s, p.sepX+start, p.sepY+p.sepH/2, p.sepW-start, text, tcell.StyleDefault.Bold(true))
+	}
+
+	// pan position, right-aligned, when the keyboard does not fit
+	if v.pan && (keyboardBottom(keys) > p.viewH || keyboardRight(keys) > p.viewW) {
+		hint := fmt.Sprintf(" rows %d-%d of %d, Shift+arrows pan ", v.oy, v.oy+p.viewH, keyboardBottom(keys))
+		drawText(s, max(p.sepX+p.sepW-len(hint)-1, p.sepX), p.sepY, p.sepW, hint, tcell.StyleDefault.Reverse(true))
+	}
+
+	// prompt, then the warning banner centered on the separator
+	if v.prompt != "" {
+		drawText(s, p.sepX+2, p.sepY, p.sepW-2, " "+v.prompt+" ", yellow)
+	}
+	if banner != "" {
+		text := " " + banner + " "
+		start := max((p.sepW-len([]rune(text)))/2, 0)
+		drawText(s, p.sepX+start, p.sepY, p.sepW-start, text, red.Bold(true))
+	}
+
+	// draw log lines, newest at the bottom if a resize left too little room
+	if len(logs) > p.logH {
+		logs = logs[len(logs)-max(p.logH, 0):]
+	}
+	for i, line := range logs {
+		drawText(s, p.logX, p.logY+i, p.logW, line, tcell.StyleDefault)
+	}
+
+	// hover tooltip last, over whatever is below the key under the mouse
+	if v.hover >= 0 && v.hover < len(v.keys) {
+		k := v.keys[v.hover]
+		tip := " " + v.tooltip(k.Label, time.Now()) + " "
+		x, y := max(k.X-v.ox, 0), k.Y+k.H-v.oy
+		if y >= h {
+			y = k.Y - 1 - v.oy
+		}
+		drawText(s, x, y, w-x, tip, tcell.StyleDefault.Reverse(true))
 	}
 }
 
-func drawKey(s tcell.Screen, k Key, style tcell.Style) {
+// drawText writes text from x,y, clipped to width cells
+func drawText(s tcell.Screen, x, y, width int, text string, style tcell.Style) {
+	i := 0
+	for _, r := range text {
+		if i >= width {
+			break
+		}
+		s.SetContent(x+i, y, r, nil, style)
+		i++
+	}
+}
+
+// drawKey fills the key box and centers the label on its top row by display
+// width, leaving any odd cell on the right. A label wider than the key is
+// cut short with an ellipsis so it never spills into its neighbours.
+func drawKey(s tcell.Screen, k Key, style tcell.Style, keycaps bool) {
 	for dx := 0; dx < k.W; dx++ {
 		for dy := 0; dy < k.H; dy++ {
 			s.SetContent(k.X+dx, k.Y+dy, ' ', nil, style)
 		}
 	}
-	start := k.X + (k.W-len(k.Label))/2
-	for i, r := range k.Label {
-		s.SetContent(start+i, k.Y, r, nil, style)
+	if keycaps && isKeycap(k.Label) && k.W >= 2 {
+		drawKeycap(s, k.X+(k.W-2)/2, k.Y, rune(k.Label[0]), style)
+		return
+	}
+	label := k.Label
+	if runewidth.StringWidth(label) > k.W {
+		label = runewidth.Truncate(label, k.W, "…")
+	}
+	x := k.X + (k.W-runewidth.StringWidth(label))/2
+	for _, r := range label {
+		rw := runewidth.RuneWidth(r)
+		if x+rw > k.X+k.W {
+			break
+		}
+		s.SetContent(x, k.Y, r, nil, style)
+		x += rw
 	}
 }
 
@@ -200,10 +1401,195 @@ func labelFromEvent(ev *tcell.EventKey) string {
 		if ev.Key() >= tcell.KeyCtrlA && ev.Key() <= tcell.KeyCtrlZ {
 			return string('A' + rune(ev.Key()-tcell.KeyCtrlA))
 		}
+		if ev.Key() >= tcell.KeyF13 && ev.Key() <= tcell.KeyF64 {
+			return tcell.KeyNames[ev.Key()]
+		}
 		return fmt.Sprintf("Key[%d]", ev.Key())
 	}
 }
 
+// sentCode returns the code of the key an event came from. Ctrl+A to Ctrl+Z
+// arrive as KeyCtrlA to KeyCtrlZ but come from letter keys, which send runes.
+func sentCode(ev *tcell.EventKey) tcell.Key {
+	if k := ev.Key(); k >= tcell.KeyCtrlA && k <= tcell.KeyCtrlZ && utf8.RuneCountInString(labelFromEvent(ev)) == 1 {
+		return tcell.KeyRune
+	}
+	return ev.Key()
+}
+
+// panStep returns the pan offset change for an arrow key, one key unit
+// per press
+func panStep(k tcell.Key) (dx, dy int, ok bool) {
+	switch k {
+	case tcell.KeyLeft:
+		return -cellsPerUnitX, 0, true
+	case tcell.KeyRight:
+		return cellsPerUnitX, 0, true
+	case tcell.KeyUp:
+		return 0, -cellsPerUnitY, true
+	case tcell.KeyDown:
+		return 0, cellsPerUnitY, true
+	}
+	return 0, 0, false
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// keyHold follows the run of events of the key being held, as first press
+// and autorepeat, since terminals do not report releases
+type keyHold struct {
+	label       string
+	start, last time.Time
+	reported    bool
+}
+
+// press feeds one key event and reports true once per hold, when the key
+// has been held for need. With need zero every press reports true.
+func (h *keyHold) press(label string, at time.Time, need time.Duration) bool {
+	if need == 0 {
+		return true
+	}
+	if label != h.label || at.Sub(h.last) > holdGap {
+		*h = keyHold{label: label, start: at}
+	}
+	h.last = at
+	if h.reported || at.Sub(h.start) < need {
+		return false
+	}
+	h.reported = true
+	return true
+}
+
+// startupSettle is how long the terminal size must stay the same before
+// -startup-sync takes it as final
+const startupSettle = 250 * time.Millisecond
+
+// startupTimeout is posted when -startup-sync stops waiting
+type startupTimeout struct{}
+
+// startupSettled is posted startupSettle after the nth resize
+type startupSettled struct{ n int }
+
+// waitForSize waits until the terminal size has stayed the same for
+// startupSettle or timeout passes. Init queues a resize for the size it
+// found itself, so a single resize is not enough: a terminal that reports
+// its real size late sends another, which restarts the wait. It returns the
+// other events that arrived meanwhile, to be posted again.
+func waitForSize(s tcell.Screen, timeout time.Duration) []tcell.Event {
+	t := time.AfterFunc(timeout, func() {
+		s.PostEvent(tcell.NewEventInterrupt(startupTimeout{}))
+	})
+	defer t.Stop()
+	settle := time.AfterFunc(startupSettle, func() {
+		s.PostEvent(tcell.NewEventInterrupt(startupSettled{0}))
+	})
+	defer func() { settle.Stop() }()
+	resizes := 0
+	var held []tcell.Event
+	for {
+		switch ev := s.PollEvent().(type) {
+		case *tcell.EventResize:
+			resizes++
+			n := resizes
+			settle.Stop()
+			settle = time.AfterFunc(startupSettle, func() {
+				s.PostEvent(tcell.NewEventInterrupt(startupSettled{n}))
+			})
+		case *tcell.EventInterrupt:
+			switch d := ev.Data().(type) {
+			case startupTimeout:
+				s.Sync()
+				return held
+			case startupSettled:
+				if d.n == resizes {
+					s.Sync()
+					return held
+				}
+			default:
+				held = append(held, ev)
+			}
+		case nil:
+			return held
+		default:
+			held = append(held, ev)
+		}
+	}
+}
+
+// teardownOnce returns a function that finalises s the first time it is
+// called and does nothing after that
+func teardownOnce(s tcell.Screen) func() {
+	var once sync.Once
+	return func() { once.Do(s.Fini) }
+}
+
+// signalReceived is posted when the process is asked to stop
+type signalReceived struct {
+	sig os.Signal
+}
+
+// watchSignals turns termination signals into events for the loop, so they
+// leave through the same path as every other exit
+func watchSignals(s tcell.Screen) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range ch {
+			s.PostEvent(tcell.NewEventInterrupt(signalReceived{sig}))
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
+
+// signalExitCode follows the shell convention of 128 plus the signal number
+func signalExitCode(sig os.Signal) int {
+	if n, ok := sig.(syscall.Signal); ok {
+		return 128 + int(n)
+	}
+	return 1
+}
+
+// clockTick is posted every second while the stats show running times
+type clockTick struct{}
+
+// watchdogTimeout is posted once the -watchdog window has passed
+type watchdogTimeout struct{}
+
+// coalesceTimeout is posted when the -coalesce-mods window of a held-back
+// modifier event has passed
+type coalesceTimeout struct{}
+
+// isModifierOnly reports whether the event carries modifiers but no key
+// tcell defines, as sent by terminals that report a bare modifier press.
+// Pause, Print, Help and the other keys labelFromEvent does not name are
+// still real keys.
+func isModifierOnly(ev *tcell.EventKey) bool {
+	return ev.Modifiers() != 0 && ev.Key() > tcell.KeyF64
+}
+
+// eventMods returns the event's modifiers, adding Ctrl for the control keys
+// that terminals report without a modifier. Tab, Enter and Backspace share
+// codes with Ctrl+I, Ctrl+M and Ctrl+H but are keys of their own.
+func eventMods(ev *tcell.EventKey) tcell.ModMask {
+	m := ev.Modifiers()
+	switch ev.Key() {
+	case tcell.KeyTab, tcell.KeyEnter, tcell.KeyBackspace:
+	default:
+		if ev.Key() >= tcell.KeyCtrlA && ev.Key() <= tcell.KeyCtrlZ {
+			m |= tcell.ModCtrl
+		}
+	}
+	return m
+}
+
 func modString(m tcell.ModMask) string {
 	var parts []string
 	if m&tcell.ModCtrl != 0 {
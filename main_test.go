@@ -0,0 +1,409 @@
+package main
+
+import (
+	"database/sql"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// newTestScreen returns an initialised w by h simulation screen
+func newTestScreen(t *testing.T, w, h int) tcell.SimulationScreen {
+	t.Helper()
+	s := tcell.NewSimulationScreen("UTF-8")
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetSize(w, h)
+	t.Cleanup(s.Fini)
+	return s
+}
+
+// rowText returns the runes shown in cells x to x+n-1 of row y
+func rowText(s tcell.SimulationScreen, x, y, n int) string {
+	cells, w, _ := s.GetContents()
+	var out []rune
+	for i := x; i < x+n; i++ {
+		c := cells[y*w+i]
+		if len(c.Runes) == 0 {
+			out = append(out, ' ')
+			continue
+		}
+		out = append(out, c.Runes[0])
+	}
+	return string(out)
+}
+
+func TestDrawKeyLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string
+		w     int
+		want  string // the key's top row, with one cell either side
+	}{
+		{"fits odd", "A", 3, "| A |"},
+		{"fits even", "A", 4, "| A  |"},
+		{"odd in even", "Esc", 4, "|Esc |"},
+		{"even in odd", "F1", 5, "| F1  |"},
+		{"too long", "Backspace", 5, "|Back…|"},
+		{"too long by one", "Home", 3, "|Ho…|"},
+		{"single cell", "Delete", 1, "|…|"},
+		{"empty", "", 4, "|    |"},
+		{"multibyte", "Ü", 3, "| Ü |"},
+		{"multibyte even", "É", 4, "| É  |"},
+		{"multibyte word", "ÄÖÜ", 5, "| ÄÖÜ |"},
+		{"multibyte too long", "ÄÖÜß", 3, "|ÄÖ…|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScreen(t, 20, 5)
+			for x := 0; x < 20; x++ {
+				s.SetContent(x, 1, '|', nil, tcell.StyleDefault)
+			}
+			k := Key{Label: tt.label, X: 1, Y: 1, W: tt.w, H: 2}
+			drawKey(s, k, tcell.StyleDefault, false)
+			s.Show()
+			if got := rowText(s, 0, 1, tt.w+2); got != tt.want {
+				t.Errorf("drawKey(%q, W=%d) top row = %q, want %q", tt.label, tt.w, got, tt.want)
+			}
+			if got, want := rowText(s, 0, 2, tt.w+2), " "+strings.Repeat(" ", tt.w)+" "; got != want {
+				t.Errorf("drawKey(%q, W=%d) second row = %q, want %q", tt.label, tt.w, got, want)
+			}
+		})
+	}
+}
+
+// finiCounter counts the calls to Fini of the screen it wraps
+type finiCounter struct {
+	tcell.Screen
+	n int
+}
+
+func (f *finiCounter) Fini() {
+	f.n++
+	f.Screen.Fini()
+}
+
+// newTestView returns the view run would build for the us layout
+func newTestView() *view {
+	return &view{
+		keys:       initKeys("us", false),
+		pressed:    map[string]bool{},
+		counts:     map[string]int{},
+		lastPress:  map[string]time.Time{},
+		hover:      -1,
+		combos:     map[tcell.ModMask]bool{},
+		mismatched: map[string]bool{},
+	}
+}
+
+func TestSignalExit(t *testing.T) {
+	// not newTestScreen: finalising is what is under test here
+	sim := tcell.NewSimulationScreen("UTF-8")
+	if err := sim.Init(); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetSize(120, 50)
+	s := &finiCounter{Screen: sim}
+	teardown := teardownOnce(s)
+	defer teardown()
+
+	cfg := config{Layout: "us", Paste: "keys", OnComplete: "continue", Guided: true}
+	s.PostEvent(tcell.NewEventInterrupt(signalReceived{syscall.SIGTERM}))
+	code, note := loop(s, cfg, newTestView(), nil, nil)
+	teardown()
+	teardown()
+
+	if code != 143 {
+		t.Errorf("exit code = %d, want 143", code)
+	}
+	want := "guided: 0/74 keys pressed, 0 wrong presses, 0 transpositions\nstopped by signal: terminated"
+	if note != want {
+		t.Errorf("note = %q, want %q", note, want)
+	}
+	if s.n != 1 {
+		t.Errorf("Fini called %d times, want 1", s.n)
+	}
+}
+
+func TestForbidSpecsMatchEvents(t *testing.T) {
+	tests := []struct {
+		spec string
+		ev   *tcell.EventKey
+	}{
+		{"F13", tcell.NewEventKey(tcell.KeyF13, 0, tcell.ModNone)},
+		{"F64", tcell.NewEventKey(tcell.KeyF64, 0, tcell.ModNone)},
+		{"Ctrl+Q", tcell.NewEventKey(tcell.KeyCtrlQ, 0, tcell.ModCtrl)},
+		{"q", tcell.NewEventKey(tcell.KeyRune, 'q', tcell.ModNone)},
+		{"Alt+ü", tcell.NewEventKey(tcell.KeyRune, 'ü', tcell.ModAlt)},
+	}
+	for _, tt := range tests {
+		st := parseStep(tt.spec)
+		if got := labelFromEvent(tt.ev); got != st.Label || tt.ev.Modifiers()&st.Mods != st.Mods {
+			t.Errorf("%q parses to %q %v, the event is %q %v", tt.spec, st.Label, st.Mods, got, tt.ev.Modifiers())
+		}
+	}
+}
+
+func TestWriteStatusMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	if err := writeStatus(path, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0o644 {
+		t.Errorf("status file mode = %v, want %v", mode, os.FileMode(0o644))
+	}
+}
+
+func TestTooltip(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 5, 0, time.UTC)
+	v := newTestView()
+	v.counts["A"] = 3 // seeded, never pressed this run
+	v.counts["B"], v.lastPress["B"] = 1, now.Add(-2*time.Second)
+	for label, want := range map[string]string{
+		"A": "A: 3 presses",
+		"B": "B: 1 presses, last: 12:00:03, 2s ago",
+		"C": "C: never pressed",
+	} {
+		if got := v.tooltip(label, now); got != want {
+			t.Errorf("tooltip(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
+
+func TestSentCode(t *testing.T) {
+	tests := []struct {
+		ev   *tcell.EventKey
+		want tcell.Key
+	}{
+		{tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone), tcell.KeyRune},
+		{tcell.NewEventKey(tcell.KeyCtrlA, 0, tcell.ModCtrl), tcell.KeyRune},
+		{tcell.NewEventKey(tcell.KeyCtrlZ, 0, tcell.ModCtrl), tcell.KeyRune},
+		{tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone), tcell.KeyTab},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), tcell.KeyEnter},
+		{tcell.NewEventKey(tcell.KeyBackspace, 0, tcell.ModNone), tcell.KeyBackspace},
+		{tcell.NewEventKey(tcell.KeyF5, 0, tcell.ModNone), tcell.KeyF5},
+	}
+	for _, tt := range tests {
+		if got := sentCode(tt.ev); got != tt.want {
+			t.Errorf("sentCode(%s) = %d, want %d", tt.ev.Name(), got, tt.want)
+		}
+	}
+}
+
+func TestGuidedReprompt(t *testing.T) {
+	keys := []Key{{Label: "A"}, {Label: "B"}}
+	now := time.Now()
+	g := newGuided(keys, "")
+	g.maxAttempts = 2
+
+	g.press("B", now) // a wrong press is no prompt
+	if line := g.reprompt(now); line != "guided: still waiting for A, prompt 2" {
+		t.Errorf("first reprompt = %q", line)
+	}
+	if g.takeAlarm() != "" || g.expected() != "A" {
+		t.Fatalf("A failed after one prompt")
+	}
+	if line := g.reprompt(now); line != "guided: no press of A after 2 prompts; A FAILED" {
+		t.Errorf("second reprompt = %q", line)
+	}
+	if alarm := g.takeAlarm(); alarm != "DEAD KEY? A did not register in 2 prompts" {
+		t.Errorf("alarm = %q", alarm)
+	}
+	if g.expected() != "B" || !slices.Equal(g.failed, []string{"A"}) {
+		t.Errorf("after failing A: expected %q, failed %v", g.expected(), g.failed)
+	}
+
+	g.hold = time.Second
+	g.press("B", now)
+	if line := g.reprompt(now.Add(100 * time.Millisecond)); line != "" || g.attempts != 0 {
+		t.Errorf("reprompt during a hold = %q, %d attempts", line, g.attempts)
+	}
+}
+
+func TestWaitForSize(t *testing.T) {
+	s := newTestScreen(t, 80, 25)
+	key := tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone)
+	s.PostEvent(tcell.NewEventResize(80, 25))
+	s.PostEvent(key)
+	// the real size arrives late
+	go func() {
+		time.Sleep(startupSettle / 2)
+		s.PostEvent(tcell.NewEventResize(120, 40))
+	}()
+	start := time.Now()
+	held := waitForSize(s, 10*time.Second)
+	if took := time.Since(start); took < startupSettle*3/2 || took > 5*time.Second {
+		t.Errorf("waitForSize returned after %v, want the late resize plus %v", took, startupSettle)
+	}
+	if len(held) != 1 || held[0] != key {
+		t.Errorf("held = %v, want the key event", held)
+	}
+
+	// a size that never settles still ends at the timeout
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for w := 1; ; w++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(startupSettle / 5):
+				s.PostEvent(tcell.NewEventResize(w, 25))
+			}
+		}
+	}()
+	start = time.Now()
+	waitForSize(s, 3*startupSettle)
+	if took := time.Since(start); took > 10*startupSettle {
+		t.Errorf("waitForSize ignored its timeout, returned after %v", took)
+	}
+}
+
+func TestOptionKey(t *testing.T) {
+	tests := []struct {
+		layout string
+		r      rune
+		want   string
+		ok     bool
+	}{
+		{"us", 'ß', "S", true},
+		{"us", 'å', "A", true},
+		{"us", 'a', "", false},
+		{"de", 'ß', "", false},
+		{"de", 'µ', "", false},
+	}
+	for _, tt := range tests {
+		got, ok := optionKey(tt.layout, tcell.NewEventKey(tcell.KeyRune, tt.r, tcell.ModNone))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("optionKey(%q, %q) = %q, %v, want %q, %v", tt.layout, tt.r, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSQLiteFlushesAfterBurst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	l, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	l.keyEvent(tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone), "A")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	deadline := time.Now().Add(sqliteFlush + 2*time.Second)
+	for {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM events").Scan(&n); err == nil && n == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the row of a lone key was not committed within %v", sqliteFlush)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNewPianoPlayer(t *testing.T) {
+	keys := initKeys("us", false)
+	if _, err := newPiano(keys, "", "no-such-player-xyz -q"); err == nil || !strings.Contains(err.Error(), "no-such-player-xyz") {
+		t.Errorf("missing player: err = %v, want it named", err)
+	}
+	if _, err := newPiano(keys, "", "sh -c true"); err != nil {
+		t.Errorf("existing player: %v", err)
+	}
+}
+
+func TestResetKeepsSeed(t *testing.T) {
+	v := newTestView()
+	v.seed = map[string]int{"A": 5}
+	maps.Copy(v.counts, v.seed)
+	v.counts["A"]++
+	v.counts["B"] = 1
+	v.reset(time.Now())
+	if want := map[string]int{"A": 5}; !maps.Equal(v.counts, want) {
+		t.Errorf("counts after reset = %v, want %v", v.counts, want)
+	}
+}
+
+func TestIsModifierOnly(t *testing.T) {
+	tests := []struct {
+		ev   *tcell.EventKey
+		want bool
+	}{
+		{tcell.NewEventKey(tcell.Key(9999), 0, tcell.ModShift), true},
+		{tcell.NewEventKey(tcell.Key(9999), 0, tcell.ModNone), false},
+		{tcell.NewEventKey(tcell.KeyPause, 0, tcell.ModCtrl), false},
+		{tcell.NewEventKey(tcell.KeyPrint, 0, tcell.ModShift), false},
+		{tcell.NewEventKey(tcell.KeyHelp, 0, tcell.ModAlt), false},
+		{tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModAlt), false},
+		{tcell.NewEventKey(tcell.KeyClear, 0, tcell.ModShift), false},
+		{tcell.NewEventKey(tcell.KeyCtrlBackslash, 0, tcell.ModCtrl), false},
+	}
+	for _, tt := range tests {
+		if got := isModifierOnly(tt.ev); got != tt.want {
+			t.Errorf("isModifierOnly(%s) = %v, want %v", tt.ev.Name(), got, tt.want)
+		}
+	}
+}
+
+func TestTranspositionHighlight(t *testing.T) {
+	g := newGuided([]Key{{Label: "1"}, {Label: "2"}, {Label: "3"}}, "")
+	now := time.Now()
+	g.press("2", now)
+	g.press("1", now)
+	if !slices.Equal(g.swapped, []string{"1", "2"}) {
+		t.Fatalf("swapped = %v, want [1 2]", g.swapped)
+	}
+
+	s := newTestScreen(t, 200, 60)
+	v := newTestView()
+	v.transposed, v.failed = g.swapped, []string{"3"}
+	drawAll(s, v)
+	s.Show()
+	bg := func(label string) tcell.Color {
+		k, ok := findKey(v.keys, label)
+		if !ok {
+			t.Fatalf("no %s key", label)
+		}
+		_, _, style, _ := s.GetContent(k.X, k.Y)
+		_, b, _ := style.Decompose()
+		return b
+	}
+	for _, label := range []string{"1", "2"} {
+		if got := bg(label); got != tcell.ColorPurple {
+			t.Errorf("transposed key %s background = %v, want purple", label, got)
+		}
+	}
+	if got := bg("3"); got != tcell.ColorRed {
+		t.Errorf("failed key 3 background = %v, want red", got)
+	}
+}
+
+func TestUniqueKeys(t *testing.T) {
+	keys := []Key{{Label: "Shift"}, {Label: "A"}, {Label: "Shift"}, {Label: "B"}, {Label: "A"}}
+	if got, want := uniqueKeys(keys), []int{0, 1, 3}; !slices.Equal(got, want) {
+		t.Errorf("uniqueKeys = %v, want %v", got, want)
+	}
+	if got := labelList(nil); got != "none" {
+		t.Errorf("labelList(nil) = %q, want none", got)
+	}
+	if got := labelList([]string{"A", "B"}); got != "A B" {
+		t.Errorf("labelList = %q, want \"A B\"", got)
+	}
+}
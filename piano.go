@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	pianoRate     = 44100
+	pianoNoteLen  = 180 * time.Millisecond
+	pianoMinGap   = 40 * time.Millisecond // drop notes closer together, e.g. autorepeat
+	defaultPlayer = "aplay -q -f S16_LE -r 44100 -c 1"
+)
+
+// piano plays a note for each mapped key by piping synthesized 16-bit mono
+// PCM into an external player command
+type piano struct {
+	player []string
+	notes  map[string]float64 // label -> frequency in Hz
+	last   time.Time
+}
+
+// newPiano builds the note map from the layout and, if mapFile is set,
+// overrides it with "LABEL=NOTE" lines such as "A=C4" or "1=F#5"
+func newPiano(keys []Key, mapFile, player string) (*piano, error) {
+	p := &piano{player: strings.Fields(player), notes: defaultNotes(keys)}
+	if len(p.player) == 0 {
+		return nil, fmt.Errorf("empty audio player command")
+	}
+	if _, err := exec.LookPath(p.player[0]); err != nil {
+		return nil, fmt.Errorf("audio player %q not found, set one with -audio-cmd", p.player[0])
+	}
+	if mapFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(mapFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected LABEL=NOTE", mapFile, n)
+		}
+		label, note := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if note == "" || note == "-" {
+			delete(p.notes, label)
+			continue
+		}
+		freq, err := noteFreq(note)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", mapFile, n, err)
+		}
+		p.notes[label] = freq
+	}
+	return p, sc.Err()
+}
+
+// defaultNotes walks each layout row left to right up a C major scale, one
+// octave lower per row down the board
+func defaultNotes(keys []Key) map[string]float64 {
+	rows := map[int][]Key{}
+	var ys []int
+	for _, k := range keys {
+		if _, ok := rows[k.Y]; !ok {
+			ys = append(ys, k.Y)
+		}
+		rows[k.Y] = append(rows[k.Y], k)
+	}
+	sort.Ints(ys)
+
+	major := []int{0, 2, 4, 5, 7, 9, 11}
+	notes := map[string]float64{}
+	for r, y := range ys {
+		row := rows[y]
+		sort.Slice(row, func(i, j int) bool { return row[i].X < row[j].X })
+		base := max(84-12*r, 36) // C6 for the top row, never below C2
+		for i, k := range row {
+			if _, ok := notes[k.Label]; ok {
+				continue
+			}
+			midi := base + 12*(i/len(major)) + major[i%len(major)]
+			notes[k.Label] = midiFreq(midi)
+		}
+	}
+	return notes
+}
+
+// noteFreq parses a note name like "C4", "F#5" or "Bb3"
+func noteFreq(note string) (float64, error) {
+	semis := map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+	n, ok := semis[strings.ToUpper(note[:1])[0]]
+	if !ok {
+		return 0, fmt.Errorf("bad note %q", note)
+	}
+	rest := note[1:]
+	switch {
+	case strings.HasPrefix(rest, "#"):
+		n++
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "b"):
+		n--
+		rest = rest[1:]
+	}
+	octave, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, fmt.Errorf("bad octave in note %q", note)
+	}
+	return midiFreq(12*(octave+1) + n), nil
+}
+
+func midiFreq(midi int) float64 {
+	return 440 * math.Pow(2, float64(midi-69)/12)
+}
+
+// keyEvent starts the note for label, if it has one. Errors from the
+// player are ignored so a missing audio device never disturbs the test.
+func (p *piano) keyEvent(ev *tcell.EventKey, label string) {
+	freq, ok := p.notes[label]
+	if !ok || ev.When().Sub(p.last) < pianoMinGap {
+		return
+	}
+	p.last = ev.When()
+
+	cmd := exec.Command(p.player[0], p.player[1:]...)
+	cmd.Stdin = bytes.NewReader(tone(freq, pianoNoteLen))
+	if err := cmd.Start(); err != nil {
+		return
+	}
+	go cmd.Wait()
+}
+
+func (p *piano) close() error {
+	return nil
+}
+
+// tone synthesizes a sine wave with a short attack and linear decay
+func tone(freq float64, d time.Duration) []byte {
+	samples := int(float64(pianoRate) * d.Seconds())
+	attack := pianoRate / 200
+	buf := make([]byte, 2*samples)
+	for i := 0; i < samples; i++ {
+		env := 1 - float64(i)/float64(samples)
+		if i < attack {
+			env *= float64(i) / float64(attack)
+		}
+		v := 0.4 * env * math.Sin(2*math.Pi*freq*float64(i)/pianoRate)
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(int16(v*math.MaxInt16)))
+	}
+	return buf
+}
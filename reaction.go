@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// reactionPrompt is posted when the next reaction-test target is due
+type reactionPrompt struct{}
+
+// reactionTest prompts random keys and times how long each correct press
+// takes
+type reactionTest struct {
+	rounds  int
+	targets []string
+	target  string // current prompt, empty while waiting for the next one
+	shown   time.Time
+	times   []time.Duration
+	wrong   int
+}
+
+// newReactionTest picks its targets from the layout keys a terminal can
+// report on their own. Esc, Enter and Space are left out as they count
+// towards exit. It returns nil if no key qualifies.
+func newReactionTest(rounds int, keys []Key) *reactionTest {
+	rt := &reactionTest{rounds: rounds}
+	for _, i := range uniqueKeys(keys) {
+		label := keys[i].Label
+		switch {
+		case !reportable(label):
+		case label == "Esc", label == "Enter", label == "Space":
+		default:
+			rt.targets = append(rt.targets, label)
+		}
+	}
+	if len(rt.targets) == 0 {
+		return nil
+	}
+	return rt
+}
+
+// reportable reports whether labelFromEvent can produce label
+func reportable(label string) bool {
+	if len([]rune(label)) == 1 {
+		return true
+	}
+	switch label {
+	case "Esc", "Enter", "Tab", "Backspace", "Space":
+		return true
+	}
+	for _, name := range tcell.KeyNames {
+		if name == label {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns a random wait before the next prompt so it cannot be
+// anticipated
+func (rt *reactionTest) delay() time.Duration {
+	return 500*time.Millisecond + time.Duration(rand.Int63n(int64(1500*time.Millisecond)))
+}
+
+// prompt shows a new random target
+func (rt *reactionTest) prompt(now time.Time) {
+	rt.target = rt.targets[rand.Intn(len(rt.targets))]
+	rt.shown = now
+}
+
+// press scores one key press and returns the log line for it, reporting
+// whether it was the prompted key
+func (rt *reactionTest) press(label string, at time.Time) (string, bool) {
+	switch {
+	case rt.target == "":
+		rt.wrong++
+		return fmt.Sprintf("reaction: %s pressed before the prompt", label), false
+	case label != rt.target:
+		rt.wrong++
+		return fmt.Sprintf("reaction: wrong key %s, expected %s", label, rt.target), false
+	}
+	d := at.Sub(rt.shown)
+	rt.times = append(rt.times, d)
+	rt.target = ""
+	return fmt.Sprintf("reaction: round %d/%d %s in %v", len(rt.times), rt.rounds, label, d.Round(time.Millisecond)), true
+}
+
+func (rt *reactionTest) done() bool {
+	return len(rt.times) >= rt.rounds
+}
+
+// status is the prompt shown on screen
+func (rt *reactionTest) status() string {
+	if rt.target == "" {
+		return fmt.Sprintf("REACTION %d/%d: get ready...", len(rt.times)+1, rt.rounds)
+	}
+	return fmt.Sprintf("REACTION %d/%d: press %s", len(rt.times)+1, rt.rounds, rt.target)
+}
+
+// result summarises the completed rounds for the exit report
+func (rt *reactionTest) result() string {
+	if len(rt.times) == 0 {
+		return fmt.Sprintf("reaction: no rounds completed, %d wrong presses", rt.wrong)
+	}
+	var sum, best, worst time.Duration
+	best = rt.times[0]
+	for _, d := range rt.times {
+		sum += d
+		best = min(best, d)
+		worst = max(worst, d)
+	}
+	avg := sum / time.Duration(len(rt.times))
+	return fmt.Sprintf("reaction: %d rounds, average %v (best %v, worst %v), %d wrong presses",
+		len(rt.times), avg.Round(time.Millisecond), best.Round(time.Millisecond), worst.Round(time.Millisecond), rt.wrong)
+}
@@ -0,0 +1,118 @@
+//go:build linux && rumble
+
+package main
+
+import (
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	rumblePulse  = 60 * time.Millisecond
+	rumbleMinGap = 150 * time.Millisecond // autorepeat is faster than this
+)
+
+// evdev constants from linux/input.h and linux/input-event-codes.h
+const (
+	evFF      = 0x15
+	ffRumble  = 0x50
+	ffMax     = 0x7f
+	iocWrite  = 1
+	iocRead   = 2
+	ioctlType = 'E'
+)
+
+// ffEffect is struct ff_effect. Its union is laid out as the largest
+// member, ff_periodic_effect, so the size and alignment match the kernel's
+// on every architecture; ff_rumble_effect overlays its first two fields.
+type ffEffect struct {
+	Type      uint16
+	ID        int16
+	Direction uint16
+	Trigger   [2]uint16
+	Replay    struct{ Length, Delay uint16 }
+	U         struct {
+		Strong, Weak uint16 // ff_periodic_effect: waveform, period
+		_            [3]uint16
+		_            [4]uint16
+		_            uint32
+		_            uintptr
+	}
+}
+
+// inputEvent is struct input_event
+type inputEvent struct {
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+func ioc(dir, nr, size uintptr) uint {
+	return uint(dir<<30 | size<<16 | ioctlType<<8 | nr)
+}
+
+// rumble pulses the first force-feedback device that can rumble
+type rumble struct {
+	fd     int
+	effect int16
+	last   time.Time
+}
+
+// openRumble looks for a controller with rumble support among the evdev
+// devices. It returns nil, and no error, if there is none or it cannot be
+// opened.
+func openRumble() (eventSink, error) {
+	paths, _ := filepath.Glob("/dev/input/event*")
+	for _, path := range paths {
+		fd, err := unix.Open(path, unix.O_RDWR|unix.O_CLOEXEC, 0)
+		if err != nil {
+			continue
+		}
+		if r := newRumble(fd); r != nil {
+			return r, nil
+		}
+		unix.Close(fd)
+	}
+	return nil, nil
+}
+
+// newRumble uploads the pulse effect to fd, returning nil if the device
+// does not rumble
+func newRumble(fd int) *rumble {
+	var bits [ffMax/8 + 1]byte
+	req := ioc(iocRead, 0x20+evFF, unsafe.Sizeof(bits))
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(unsafe.Pointer(&bits))); errno != 0 {
+		return nil
+	}
+	if bits[ffRumble/8]&(1<<(ffRumble%8)) == 0 {
+		return nil
+	}
+	eff := ffEffect{Type: ffRumble, ID: -1}
+	eff.Replay.Length = uint16(rumblePulse / time.Millisecond)
+	eff.U.Strong, eff.U.Weak = 0x8000, 0x8000
+	req = ioc(iocWrite, 0x80, unsafe.Sizeof(eff))
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(unsafe.Pointer(&eff))); errno != 0 {
+		return nil
+	}
+	return &rumble{fd: fd, effect: eff.ID}
+}
+
+// keyEvent plays the pulse, skipping presses closer together than
+// rumbleMinGap so autorepeat does not turn into one long rumble
+func (r *rumble) keyEvent(ev *tcell.EventKey, label string) {
+	if ev.When().Sub(r.last) < rumbleMinGap {
+		return
+	}
+	r.last = ev.When()
+	play := inputEvent{Type: evFF, Code: uint16(r.effect), Value: 1}
+	unix.Write(r.fd, (*[unsafe.Sizeof(play)]byte)(unsafe.Pointer(&play))[:])
+}
+
+func (r *rumble) close() error {
+	return unix.Close(r.fd)
+}
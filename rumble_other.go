@@ -0,0 +1,9 @@
+//go:build !linux || !rumble
+
+package main
+
+import "errors"
+
+func openRumble() (eventSink, error) {
+	return nil, errors.New("built without rumble support, rebuild on Linux with -tags rumble")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// seqStep is one expected key of an -expect-sequence file
+type seqStep struct {
+	Label    string
+	Mods     tcell.ModMask
+	MaxDelay time.Duration // 0 means no limit
+	Line     int
+}
+
+// sequence matches incoming key events against an expected list of steps.
+// A step may match out of order as long as it is within window steps of the
+// first unmatched one.
+type sequence struct {
+	steps   []seqStep
+	matched []bool
+	next    int
+	window  int
+	last    time.Time
+	failure string
+}
+
+// loadSequence reads an expected sequence file. Each non-empty line that does
+// not start with '#' holds a key label, optionally prefixed with modifiers
+// ("Ctrl+Alt+Delete") and optionally followed by the maximum delay allowed
+// since the previous key ("A 500ms").
+func loadSequence(path string, window int) (*sequence, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	q := &sequence{window: window, last: time.Now()}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"KEY [max-delay]\"", path, n)
+		}
+		step := parseStep(fields[0])
+		step.Line = n
+		if len(fields) == 2 {
+			d, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			step.MaxDelay = d
+		}
+		q.steps = append(q.steps, step)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(q.steps) == 0 {
+		return nil, fmt.Errorf("%s: no keys in sequence", path)
+	}
+	q.matched = make([]bool, len(q.steps))
+	return q, nil
+}
+
+// parseKeySpec splits "Ctrl+Shift+A" into its label and modifier mask
+func parseKeySpec(spec string) (string, tcell.ModMask) {
+	var mods tcell.ModMask
+	for {
+		switch {
+		case strings.HasPrefix(spec, "Ctrl+") && len(spec) > 5:
+			mods |= tcell.ModCtrl
+			spec = spec[5:]
+		case strings.HasPrefix(spec, "Alt+") && len(spec) > 4:
+			mods |= tcell.ModAlt
+			spec = spec[4:]
+		case strings.HasPrefix(spec, "Shift+") && len(spec) > 6:
+			mods |= tcell.ModShift
+			spec = spec[6:]
+		default:
+			return spec, mods
+		}
+	}
+}
+
+// parseStep parses a key spec into a step, upper-casing a single-character
+// label as labelFromEvent names it
+func parseStep(spec string) seqStep {
+	var st seqStep
+	st.Label, st.Mods = parseKeySpec(spec)
+	if utf8.RuneCountInString(st.Label) == 1 {
+		st.Label = strings.ToUpper(st.Label)
+	}
+	return st
+}
+
+// feed checks one key event against the sequence and records the first
+// mismatch. It returns false once the sequence has failed.
+func (q *sequence) feed(label string, mods tcell.ModMask, at time.Time) bool {
+	if q.failure != "" || q.complete() {
+		return q.failure == ""
+	}
+	end := q.next + q.window
+	if end >= len(q.steps) {
+		end = len(q.steps) - 1
+	}
+	for i := q.next; i <= end; i++ {
+		st := q.steps[i]
+		if q.matched[i] || st.Label != label || mods&st.Mods != st.Mods {
+			continue
+		}
+		if st.MaxDelay > 0 && at.Sub(q.last) > st.MaxDelay {
+			q.failure = fmt.Sprintf("step %d (line %d): %q arrived after %v, limit %v",
+				i+1, st.Line, keySpec(st), at.Sub(q.last).Round(time.Millisecond), st.MaxDelay)
+			return false
+		}
+		q.matched[i] = true
+		q.last = at
+		for q.next < len(q.steps) && q.matched[q.next] {
+			q.next++
+		}
+		return true
+	}
+	st := q.steps[q.next]
+	q.failure = fmt.Sprintf("step %d (line %d): expected %q, got %q",
+		q.next+1, st.Line, keySpec(st), keySpec(seqStep{Label: label, Mods: mods}))
+	return false
+}
+
+// reset rewinds the sequence to its first step
+func (q *sequence) reset() {
+	clear(q.matched)
+	q.next, q.last, q.failure = 0, time.Now(), ""
+}
+
+// pending returns the key spec of the first unmatched step
+func (q *sequence) pending() string {
+	if q.failure != "" || q.complete() {
+		return ""
+	}
+	return keySpec(q.steps[q.next])
+}
+
+func (q *sequence) complete() bool {
+	return q.next >= len(q.steps)
+}
+
+// result describes the outcome for the exit report
+func (q *sequence) result() string {
+	switch {
+	case q.failure != "":
+		return "FAIL at " + q.failure
+	case q.complete():
+		return fmt.Sprintf("PASS (%d keys)", len(q.steps))
+	default:
+		st := q.steps[q.next]
+		return fmt.Sprintf("FAIL at step %d (line %d): aborted while waiting for %q", q.next+1, st.Line, keySpec(st))
+	}
+}
+
+func keySpec(st seqStep) string {
+	var b strings.Builder
+	if st.Mods&tcell.ModCtrl != 0 {
+		b.WriteString("Ctrl+")
+	}
+	if st.Mods&tcell.ModAlt != 0 {
+		b.WriteString("Alt+")
+	}
+	if st.Mods&tcell.ModShift != 0 {
+		b.WriteString("Shift+")
+	}
+	b.WriteString(st.Label)
+	return b.String()
+}
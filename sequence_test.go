@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestSequenceFeed(t *testing.T) {
+	type press struct {
+		label string
+		mods  tcell.ModMask
+		at    time.Duration // after the first press
+	}
+	tests := []struct {
+		name   string
+		file   string
+		window int
+		keys   []press
+		want   string
+	}{
+		{"in order", "A\nB\nC\n", 0, []press{{"A", 0, 0}, {"B", 0, 0}, {"C", 0, 0}}, "PASS (3 keys)"},
+		{"swap in window", "A\nB\nC\n", 1, []press{{"B", 0, 0}, {"A", 0, 0}, {"C", 0, 0}}, "PASS (3 keys)"},
+		{"window past the end", "A\nB\nC\n", 5, []press{{"C", 0, 0}, {"B", 0, 0}, {"A", 0, 0}}, "PASS (3 keys)"},
+		{"swap without window", "A\nB\n", 0, []press{{"B", 0, 0}}, `FAIL at step 1 (line 1): expected "A", got "B"`},
+		{"beyond window", "A\nB\nC\n", 1, []press{{"C", 0, 0}}, `FAIL at step 1 (line 1): expected "A", got "C"`},
+		{"repeat of a matched step", "A\nB\nC\n", 2, []press{{"B", 0, 0}, {"B", 0, 0}}, `FAIL at step 1 (line 1): expected "A", got "B"`},
+		{"comments keep line numbers", "# warm up\nA\n\nB\n", 0, []press{{"A", 0, 0}, {"C", 0, 0}}, `FAIL at step 2 (line 4): expected "B", got "C"`},
+		{"missing modifier", "Ctrl+C\n", 0, []press{{"C", 0, 0}}, `FAIL at step 1 (line 1): expected "Ctrl+C", got "C"`},
+		{"extra modifier", "Ctrl+C\n", 0, []press{{"C", tcell.ModCtrl | tcell.ModShift, 0}}, "PASS (1 keys)"},
+		{"within delay", "A\nB 100ms\n", 0, []press{{"A", 0, 0}, {"B", 0, 50 * time.Millisecond}}, "PASS (2 keys)"},
+		{"too slow", "A\nB 100ms\n", 0, []press{{"A", 0, 0}, {"B", 0, 150 * time.Millisecond}}, `FAIL at step 2 (line 2): "B" arrived after 150ms, limit 100ms`},
+		{"delay from the previous match", "A\nB\nC 100ms\n", 1, []press{{"B", 0, 0}, {"A", 0, 200 * time.Millisecond}, {"C", 0, 250 * time.Millisecond}}, "PASS (3 keys)"},
+		{"stops at the first failure", "A\nB\n", 0, []press{{"B", 0, 0}, {"A", 0, 0}, {"B", 0, 0}}, `FAIL at step 1 (line 1): expected "A", got "B"`},
+		{"aborted", "A\nB\n", 0, []press{{"A", 0, 0}}, `FAIL at step 2 (line 2): aborted while waiting for "B"`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "seq.txt")
+		if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		q, err := loadSequence(path, tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		for _, p := range tt.keys {
+			q.feed(p.label, p.mods, start.Add(p.at))
+		}
+		if got := q.result(); got != tt.want {
+			t.Errorf("%s: result = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seq.txt")
+	if err := os.WriteFile(path, []byte("A\nB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := loadSequence(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.feed("B", 0, time.Now())
+	q.reset()
+	if got := q.pending(); got != "A" {
+		t.Errorf("pending after reset = %q, want A", got)
+	}
+	if !q.feed("A", 0, time.Now()) || !q.feed("B", 0, time.Now()) || !q.complete() {
+		t.Errorf("sequence did not pass after reset: %s", q.result())
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// session is what -save-session writes and -baseline reads back
+type session struct {
+	Saved   time.Time      `json:"saved"`
+	Counts  map[string]int `json:"counts"`
+	Pressed []string       `json:"pressed"`
+}
+
+// saveSession writes the per-key counts and pressed keys of v to path
+func saveSession(path string, v *view) error {
+	ss := session{Saved: time.Now(), Counts: v.counts}
+	for label := range v.pressed {
+		ss.Pressed = append(ss.Pressed, label)
+	}
+	sort.Strings(ss.Pressed)
+	data, err := json.MarshalIndent(ss, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func loadSession(path string) (*session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ss session
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &ss, nil
+}
+
+// pressedSet returns the keys the session saw pressed
+func (ss *session) pressedSet() map[string]bool {
+	set := make(map[string]bool, len(ss.Pressed))
+	for _, label := range ss.Pressed {
+		set[label] = true
+	}
+	return set
+}
+
+// sessionDiff compares the layout keys pressed now with a baseline:
+// added were pressed now but not then, missing were pressed then but not
+// (yet) now
+func sessionDiff(keys []Key, base, pressed map[string]bool) (added, missing []string) {
+	for _, i := range uniqueKeys(keys) {
+		k := keys[i]
+		switch {
+		case pressed[k.Label] && !base[k.Label]:
+			added = append(added, k.Label)
+		case base[k.Label] && !pressed[k.Label]:
+			missing = append(missing, k.Label)
+		}
+	}
+	return added, missing
+}
+
+// diffReport describes the difference from the baseline for the exit report
+func diffReport(keys []Key, base, pressed map[string]bool) string {
+	added, missing := sessionDiff(keys, base, pressed)
+	return fmt.Sprintf("baseline: newly working: %s\nbaseline: regressed: %s", labelList(added), labelList(missing))
+}
+
+// labelList joins labels for the exit report, "none" if there are none
+func labelList(labels []string) string {
+	if len(labels) == 0 {
+		return "none"
+	}
+	return strings.Join(labels, " ")
+}
+
+// report returns the key findings of the exit report, one per line
+func (v *view) report() string {
+	var parts []string
+	if v.base != nil {
+		parts = append(parts, diffReport(v.keys, v.base, v.pressed))
+	}
+	if v.cases != nil {
+		parts = append(parts, caseReport(v.keys, v.cases))
+	}
+	if len(v.forbidden) > 0 {
+		parts = append(parts, "forbidden keys pressed: "+strings.Join(v.forbidden, " "))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// reset starts a new session at now. Only the key counters restart, from
+// the -load-counts seed if any: the layout, baseline and log stay, as does
+// the progress of the test modes.
+func (v *view) reset(now time.Time) {
+	clear(v.pressed)
+	clear(v.counts)
+	maps.Copy(v.counts, v.seed)
+	clear(v.lastPress)
+	clear(v.combos)
+	clear(v.cases)
+	clear(v.mismatched)
+	v.forbidden = nil
+	v.events = 0
+	v.start, v.lastKey, v.idle = now, now, 0
+}
+
+// sessionRotate is posted every -rotate interval
+type sessionRotate struct{}
+
+// writeReport saves the stats and report of the session ending at now to
+// a file in dir named after that time, and returns its path
+func writeReport(dir string, v *view, now time.Time) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "session: %s to %s\n", v.start.Format(time.RFC3339), now.Format(time.RFC3339))
+	for _, line := range v.stats() {
+		b.WriteString(line + "\n")
+	}
+	if r := v.report(); r != "" {
+		b.WriteString(r + "\n")
+	}
+	path := filepath.Join(dir, "keyboardtester-"+now.Format("20060102-150405")+".txt")
+	return path, os.WriteFile(path, []byte(b.String()), 0o644)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBatch and sqliteFlush bound how many events, and for how long,
+// inserts are held in one transaction. The flush runs on a timer, so the
+// rows of a burst are committed soon after its last key, not at the next.
+const (
+	sqliteBatch = 100
+	sqliteFlush = time.Second
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS events (
+	id         INTEGER PRIMARY KEY,
+	timestamp  TEXT    NOT NULL,
+	label      TEXT    NOT NULL,
+	code       INTEGER NOT NULL,
+	rune       TEXT    NOT NULL,
+	mods       TEXT    NOT NULL,
+	session_id TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_session ON events (session_id);`
+
+// sqliteLog records key events in a SQLite database, one row per event
+type sqliteLog struct {
+	mu      sync.Mutex // the flush timer commits from its own goroutine
+	db      *sql.DB
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	pending int
+	flush   *time.Timer // commits the open transaction
+	session string
+	err     error // first write error, reported on close
+}
+
+func openSQLite(path string) (*sqliteLog, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	now := time.Now()
+	return &sqliteLog{db: db, session: fmt.Sprintf("%s-%d", now.Format("20060102-150405"), os.Getpid())}, nil
+}
+
+func (l *sqliteLog) keyEvent(ev *tcell.EventKey, label string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	if l.tx == nil {
+		if l.err = l.begin(); l.err != nil {
+			return
+		}
+	}
+	r := ""
+	if ev.Key() == tcell.KeyRune {
+		r = string(ev.Rune())
+	}
+	_, l.err = l.stmt.Exec(ev.When().Format(time.RFC3339Nano), label, int(ev.Key()), r, modString(ev.Modifiers()), l.session)
+	l.pending++
+	if l.err == nil && l.pending >= sqliteBatch {
+		l.err = l.commit()
+	}
+}
+
+// flushed commits the transaction sqliteFlush after it began
+func (l *sqliteLog) flushed() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = l.commit()
+	}
+}
+
+func (l *sqliteLog) begin() error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO events (timestamp, label, code, rune, mods, session_id) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	l.tx, l.stmt, l.pending = tx, stmt, 0
+	l.flush = time.AfterFunc(sqliteFlush, l.flushed)
+	return nil
+}
+
+func (l *sqliteLog) commit() error {
+	if l.tx == nil {
+		return nil
+	}
+	l.flush.Stop()
+	l.stmt.Close()
+	err := l.tx.Commit()
+	l.tx, l.stmt = nil, nil
+	return err
+}
+
+// close commits the open batch and closes the database
+func (l *sqliteLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = l.commit()
+	} else if l.tx != nil {
+		l.flush.Stop()
+		l.tx.Rollback()
+	}
+	if err := l.db.Close(); l.err == nil {
+		l.err = err
+	}
+	if l.err != nil {
+		return fmt.Errorf("sqlite: %v", l.err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// status is what -status-file holds for a dashboard to poll
+type status struct {
+	Updated time.Time `json:"updated"`
+	Tested  int       `json:"tested"`
+	Total   int       `json:"total"`
+	Percent int       `json:"percent"`
+}
+
+// writeStatus replaces path with the current coverage. The file is written
+// next to path and renamed over it, so a reader never sees half of it.
+func writeStatus(path string, tested, total int) error {
+	st := status{Updated: time.Now(), Tested: tested, Total: total}
+	if total > 0 {
+		st.Percent = tested * 100 / total
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	// CreateTemp makes the file 0600; the status file is for other tools to read
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
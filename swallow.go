@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// swallowDelay is how long a test waits on a commonly intercepted key
+// before explaining why it may never arrive
+const swallowDelay = 5 * time.Second
+
+// swallowedKeys are keys that terminals, multiplexers, shells or window
+// managers often keep for themselves, with what to do about it
+var swallowedKeys = map[string]string{
+	"F1":      "F1 often opens the terminal's help; unbind it in the terminal's shortcut settings",
+	"F10":     "F10 often opens the terminal's menu bar; turn off the menu accelerator in its preferences",
+	"F11":     "F11 usually toggles full screen; unbind it in the terminal's shortcut settings",
+	"Ctrl+S":  "Ctrl+S is XOFF flow control; run 'stty -ixon' or turn off flow control in tmux/screen/serial console",
+	"Ctrl+Q":  "Ctrl+Q is XON flow control; run 'stty -ixon' or turn off flow control in tmux/screen/serial console",
+	"Ctrl+Z":  "Ctrl+Z suspends the foreground job in many shells and remote sessions; check 'stty susp'",
+	"Ctrl+C":  "Ctrl+C may be taken as an interrupt before it reaches us; check 'stty intr' and the terminal's copy binding",
+	"Ctrl+\\": "Ctrl+\\ may be taken as a quit signal; check 'stty quit'",
+	"Ctrl+B":  "Ctrl+B is the default tmux prefix; press it twice or test outside tmux",
+	"Ctrl+A":  "Ctrl+A is the default screen prefix; press it twice or test outside screen",
+	"Alt+Tab": "Alt+Tab is normally kept by the window manager",
+	"Alt+F4":  "Alt+F4 is normally kept by the window manager to close windows",
+}
+
+// swallowedTimeout is posted when a test has waited swallowDelay on spec
+type swallowedTimeout struct {
+	spec string
+}
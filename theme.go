@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// animFrame is how often a running press animation is redrawn
+const animFrame = 50 * time.Millisecond
+
+// animTick is posted every animFrame while a press animation runs
+type animTick struct{}
+
+// theme is the look of tested keys and how a press is animated
+type theme struct {
+	pressed   tcell.Color
+	animation string // none, flash, pulse or fade
+}
+
+// themes are the -theme choices
+var themes = map[string]theme{
+	"classic": {pressed: tcell.ColorBlue, animation: "none"},
+	"neon":    {pressed: tcell.NewHexColor(0x8a2be2), animation: "pulse"},
+	"calm":    {pressed: tcell.NewHexColor(0x2e8b57), animation: "fade"},
+	"alert":   {pressed: tcell.NewHexColor(0xb22222), animation: "flash"},
+}
+
+// animations are the -animation choices, with how long each runs
+var animations = map[string]time.Duration{
+	"none":  0,
+	"flash": 150 * time.Millisecond,
+	"pulse": 600 * time.Millisecond,
+	"fade":  800 * time.Millisecond,
+}
+
+func themeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// animating reports whether a key pressed at at is still animating at now
+func (t theme) animating(at, now time.Time) bool {
+	return now.Sub(at) < animations[t.animation]
+}
+
+// pressStyle is the style of a tested key last pressed at at
+func (t theme) pressStyle(at, now time.Time) tcell.Style {
+	base := tcell.StyleDefault.Background(t.pressed)
+	age := now.Sub(at)
+	if !t.animating(at, now) {
+		return base
+	}
+	bright := tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorBlack)
+	switch t.animation {
+	case "flash":
+		return bright
+	case "pulse":
+		if age/(100*time.Millisecond)%2 == 0 {
+			return bright
+		}
+	case "fade":
+		return tcell.StyleDefault.Background(blend(tcell.ColorWhite, t.pressed, float64(age)/float64(animations["fade"])))
+	}
+	return base
+}
+
+// blend mixes from a to b, f from 0 to 1
+func blend(a, b tcell.Color, f float64) tcell.Color {
+	ar, ag, ab := a.RGB()
+	br, bg, bb := b.RGB()
+	mix := func(x, y int32) int32 { return x + int32(float64(y-x)*f) }
+	return tcell.NewRGBColor(mix(ar, br), mix(ag, bg), mix(ab, bb))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// typingPreview is how much of the remaining text the prompt shows
+const typingPreview = 40
+
+// typingTest has the user type a text and measures speed and accuracy.
+// Wrong characters count against accuracy but do not advance.
+type typingTest struct {
+	text       []rune
+	pos, wrong int
+	start, end time.Time
+
+	// pass thresholds, zero for none
+	wpm, accuracy float64
+}
+
+// loadTypingTest reads the text to type from path, joining its lines and
+// runs of white space with single spaces
+func loadTypingTest(path string) (*typingTest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.Join(strings.Fields(string(data)), " ")
+	if text == "" {
+		return nil, fmt.Errorf("%s: no text to type", path)
+	}
+	return &typingTest{text: []rune(text)}, nil
+}
+
+// expected returns the key label of the next character, for highlighting
+func (tt *typingTest) expected() string {
+	if tt.done() {
+		return ""
+	}
+	if r := tt.text[tt.pos]; r != ' ' {
+		return strings.ToUpper(string(r))
+	}
+	return "Space"
+}
+
+// press checks one key event and returns the log line for a mistake, if
+// any. Keys that type no character are ignored.
+func (tt *typingTest) press(ev *tcell.EventKey) string {
+	if tt.done() || ev.Key() != tcell.KeyRune {
+		return ""
+	}
+	if tt.start.IsZero() {
+		tt.start = ev.When()
+	}
+	want := tt.text[tt.pos]
+	if ev.Rune() != want {
+		tt.wrong++
+		return fmt.Sprintf("typing: expected %q, got %q", want, ev.Rune())
+	}
+	tt.pos++
+	if tt.done() {
+		tt.end = ev.When()
+	}
+	return ""
+}
+
+func (tt *typingTest) done() bool {
+	return tt.pos >= len(tt.text)
+}
+
+// status is the prompt shown on screen: the text still to type
+func (tt *typingTest) status() string {
+	if tt.done() {
+		return "TYPING: complete"
+	}
+	rest := tt.text[tt.pos:]
+	if len(rest) > typingPreview {
+		rest = append(rest[:typingPreview:typingPreview], '…')
+	}
+	return fmt.Sprintf("TYPING %d/%d: %s", tt.pos, len(tt.text), string(rest))
+}
+
+// speed returns words per minute, counting five characters as a word
+func (tt *typingTest) speed() float64 {
+	d := tt.end.Sub(tt.start)
+	if !tt.done() || d <= 0 {
+		return 0
+	}
+	return float64(len(tt.text)) / 5 / d.Minutes()
+}
+
+// score returns the percentage of keystrokes that were right
+func (tt *typingTest) score() float64 {
+	if tt.pos+tt.wrong == 0 {
+		return 0
+	}
+	return 100 * float64(tt.pos) / float64(tt.pos+tt.wrong)
+}
+
+// passed reports whether the text was typed in full within the thresholds
+func (tt *typingTest) passed() bool {
+	return tt.done() && tt.speed() >= tt.wpm && tt.score() >= tt.accuracy
+}
+
+// result summarises the test for the exit report
+func (tt *typingTest) result() string {
+	verdict := "PASS"
+	if !tt.passed() {
+		verdict = "FAIL"
+	}
+	if !tt.done() {
+		return fmt.Sprintf("typing: %s, aborted after %d/%d characters, %d wrong", verdict, tt.pos, len(tt.text), tt.wrong)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "typing: %s, %.1f WPM", verdict, tt.speed())
+	if tt.wpm > 0 {
+		fmt.Fprintf(&b, " (target %.1f)", tt.wpm)
+	}
+	fmt.Fprintf(&b, ", %.1f%% accuracy", tt.score())
+	if tt.accuracy > 0 {
+		fmt.Fprintf(&b, " (target %.1f%%)", tt.accuracy)
+	}
+	return b.String()
+}
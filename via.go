@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cellsPerUnitX and cellsPerUnitY are the terminal cells one keyboard unit
+// (1u) occupies, matching the spacing of the built-in layout
+const (
+	cellsPerUnitX = 4
+	cellsPerUnitY = 4
+)
+
+// viaDefinition is the part of a VIA/VIAL keyboard definition we use
+type viaDefinition struct {
+	Name    string `json:"name"`
+	Layouts struct {
+		Keymap [][]json.RawMessage `json:"keymap"`
+	} `json:"layouts"`
+}
+
+// kleProps are the keyboard-layout-editor properties that affect position
+// and size of the following key
+type kleProps struct {
+	X *float64 `json:"x"`
+	Y *float64 `json:"y"`
+	W *float64 `json:"w"`
+	H *float64 `json:"h"`
+	D bool     `json:"d"`
+}
+
+// loadVIA reads a VIA or VIAL definition and converts its physical layout to
+// keys. The top-left legend of each key holds its matrix position and the
+// fourth its layout option; only the default choice of every option is kept.
+// Definitions carry no keycodes, so a key is labelled with its first other
+// legend or, failing that, its matrix position.
+func loadVIA(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var def viaDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(def.Layouts.Keymap) == 0 {
+		return nil, fmt.Errorf("%s: no layouts.keymap", path)
+	}
+
+	var out []Key
+	y := 0.0
+	for ri, row := range def.Layouts.Keymap {
+		x := 0.0
+		w, h, decal := 1.0, 1.0, false
+		for _, item := range row {
+			var legend string
+			if err := json.Unmarshal(item, &legend); err != nil {
+				var p kleProps
+				if err := json.Unmarshal(item, &p); err != nil {
+					return nil, fmt.Errorf("%s: row %d: %v", path, ri+1, err)
+				}
+				if p.X != nil {
+					x += *p.X
+				}
+				if p.Y != nil {
+					y += *p.Y
+				}
+				if p.W != nil {
+					w = *p.W
+				}
+				if p.H != nil {
+					h = *p.H
+				}
+				decal = p.D
+				continue
+			}
+
+			if !decal {
+				if k, ok := viaKey(legend, x, y, w, h); ok {
+					out = append(out, k)
+				}
+			}
+			x += w
+			w, h, decal = 1, 1, false
+		}
+		y++
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s: layout has no keys", path)
+	}
+	return out, nil
+}
+
+// viaKey builds the key for one legend at position x,y (in units). It
+// reports false for keys belonging to a non-default layout option.
+func viaKey(legend string, x, y, w, h float64) (Key, bool) {
+	legends := strings.Split(legend, "\n")
+	if len(legends) > 3 && legends[3] != "" {
+		if _, choice, ok := parseMatrix(legends[3]); ok && choice != 0 {
+			return Key{}, false
+		}
+	}
+
+	k := Key{
+		X: cells(x, cellsPerUnitX),
+		Y: cells(y, cellsPerUnitY),
+		W: cells(x+w, cellsPerUnitX) - cells(x, cellsPerUnitX) - 1,
+		H: cells(y+h, cellsPerUnitY) - cells(y, cellsPerUnitY) - 1,
+	}
+	k.Row, k.Col, k.HasMatrix = parseMatrix(legends[0])
+	for i, l := range legends {
+		if i != 0 && i != 3 && l != "" {
+			k.Label = l
+			break
+		}
+	}
+	if k.Label == "" {
+		k.Label = legends[0]
+	}
+	return k, true
+}
+
+// parseMatrix parses a "row,col" legend
+func parseMatrix(s string) (int, int, bool) {
+	r, c, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	row, err1 := strconv.Atoi(strings.TrimSpace(r))
+	col, err2 := strconv.Atoi(strings.TrimSpace(c))
+	return row, col, err1 == nil && err2 == nil
+}
+
+func cells(units float64, per int) int {
+	return int(math.Round(units * float64(per)))
+}
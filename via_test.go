@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestViaKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		legend     string
+		x, y, w, h float64
+		want       Key
+		ok         bool
+	}{
+		{"matrix only", "0,0", 0, 0, 1, 1, Key{Label: "0,0", W: 3, H: 3, HasMatrix: true}, true},
+		{"labelled", "1,2\nEsc", 1, 1, 1, 1, Key{Label: "Esc", X: 4, Y: 4, W: 3, H: 3, Row: 1, Col: 2, HasMatrix: true}, true},
+		{"label after blanks", "0,3\n\nTab", 0, 0, 1.5, 1, Key{Label: "Tab", W: 5, H: 3, Col: 3, HasMatrix: true}, true},
+		{"option legend is no label", "0,4\n\n\n0,0", 0.25, 0, 2.25, 1, Key{Label: "0,4", X: 1, W: 8, H: 3, Col: 4, HasMatrix: true}, true},
+		{"default option", "0,5\nA\n\n2,0", 0, 0, 1, 2, Key{Label: "A", W: 3, H: 7, Col: 5, HasMatrix: true}, true},
+		{"other option", "0,5\nB\n\n2,1", 0, 0, 1, 1, Key{}, false},
+		{"no matrix", "Fn", 0, 0, 1, 1, Key{Label: "Fn", W: 3, H: 3}, true},
+	}
+	for _, tt := range tests {
+		got, ok := viaKey(tt.legend, tt.x, tt.y, tt.w, tt.h)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%s: viaKey(%q) = %+v, %v, want %+v, %v", tt.name, tt.legend, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadVIA(t *testing.T) {
+	tests := []struct {
+		name string
+		def  string
+		want []Key
+		err  string
+	}{
+		{
+			name: "props and options",
+			def: `{"name": "t", "layouts": {"keymap": [
+				["0,0\nEsc", {"x": 0.5}, "0,1", {"w": 2}, "0,2\n\n\n0,0", "0,3\n\n\n0,1"],
+				[{"y": 0.5, "d": true}, "decal", "1,0"]
+			]}}`,
+			want: []Key{
+				{Label: "Esc", W: 3, H: 3, HasMatrix: true},
+				{Label: "0,1", X: 6, W: 3, H: 3, Col: 1, HasMatrix: true},
+				{Label: "0,2", X: 10, W: 7, H: 3, Col: 2, HasMatrix: true},
+				{Label: "1,0", X: 4, Y: 6, W: 3, H: 3, Row: 1, HasMatrix: true},
+			},
+		},
+		{name: "no keymap", def: `{"name": "t"}`, err: "no layouts.keymap"},
+		{name: "only decals", def: `{"layouts": {"keymap": [[{"d": true}, "x"]]}}`, err: "layout has no keys"},
+		{name: "bad item", def: `{"layouts": {"keymap": [[1]]}}`, err: "row 1"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "via.json")
+		if err := os.WriteFile(path, []byte(tt.def), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadVIA(path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: keys = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}